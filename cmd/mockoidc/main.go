@@ -0,0 +1,37 @@
+// mockoidc はローカル開発用のOIDCプロバイダーを起動する。
+// 認可エンドポイントにアクセスすると、フラグで指定したユーザーとして即座にログインしたことになる。
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"message-board/internal/oidc/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "待ち受けるアドレス")
+	issuer := flag.String("issuer", "http://localhost:9000", "発行者URL（OIDC_ISSUER_URL に設定する値）")
+	clientID := flag.String("client-id", "message-board", "クライアントID")
+	clientSecret := flag.String("client-secret", "secret", "クライアントシークレット")
+	subject := flag.String("sub", "mock-user-1", "ログインするユーザーの識別子")
+	username := flag.String("username", "mockuser", "ログインするユーザーの preferred_username")
+	email := flag.String("email", "mockuser@example.com", "ログインするユーザーのメールアドレス（確認済みとして扱う）")
+	flag.Parse()
+
+	provider, err := oidctest.NewProvider(*issuer, *clientID, *clientSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	provider.SetUser(oidctest.User{
+		Subject:           *subject,
+		Email:             *email,
+		EmailVerified:     *email != "",
+		PreferredUsername: *username,
+		Name:              *username,
+	})
+
+	log.Printf("モックOIDCプロバイダーを %s で起動します（issuer: %s）", *addr, *issuer)
+	log.Fatal(http.ListenAndServe(*addr, provider))
+}
@@ -4,16 +4,28 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"message-board/internal/handlers"
+	"message-board/internal/mailer"
 	"message-board/internal/models"
+	"message-board/internal/oidc"
+	"message-board/internal/validation"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	_ "github.com/lib/pq"
 )
 
+// ゴミ箱のメッセージを完全に削除する間隔
+const trashPurgeInterval = time.Hour
+
+// データベースの最大接続数
+const maxOpenConns = 25
+
 func main() {
 	// 環境変数を使用してデータベース接続文字列を構築
 	dbHost := os.Getenv("POSTGRES_HOST")
@@ -30,6 +42,9 @@ func main() {
 		log.Fatal(err)
 	}
 	defer db.Close()
+	// ログインの集中などでデータベースの接続を使い切らないよう、接続数に上限を設ける
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxOpenConns)
 
 	// データベース接続のテスト
 	if err := db.Ping(); err != nil {
@@ -38,13 +53,55 @@ func main() {
 
 	// storeとhandlerの作成
 	messageStore := models.NewMessageStore(db)
+	postStore := models.NewPostStore(db)
 	userStore := models.NewUserStore(db)
-	messageHandler := handlers.NewMessageHandler(messageStore)
-	authHandler := handlers.NewAuthHandler(userStore)
+	tokenStore := models.NewTokenStore(db)
+	sessionStore := models.NewSessionStore(db)
+	passwordResetStore := models.NewPasswordResetStore(db)
+	emailVerificationStore := models.NewEmailVerificationStore(db)
+	twoFactorStore := models.NewTwoFactorStore(db)
+	loginAttemptStore := models.NewLoginAttemptStore(db)
+	identityStore := models.NewIdentityStore(db)
+	reportStore := models.NewReportStore(db)
+	mail := mailer.FromEnv()
+	// メール内のリンクは偽装できるHostヘッダーからは作らないため、BASE_URL が必要
+	if os.Getenv("BASE_URL") == "" {
+		log.Println("BASE_URL が設定されていないため、確認メールとパスワード再設定メールは送信されません")
+	}
+	passwordPolicy := validation.PasswordPolicyFromEnv()
+	// ゴミ箱の保存期間（日数）。TRASH_RETENTION_DAYS で変更できる。
+	retentionDays := 30
+	if days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS")); err == nil && days > 0 {
+		retentionDays = days
+	}
+	go purgeTrash(messageStore, retentionDays)
+
+	messageHandler := handlers.NewMessageHandler(messageStore, postStore, retentionDays)
+	postHandler := handlers.NewPostHandler(postStore, messageStore)
+	authHandler := handlers.NewAuthHandler(userStore, tokenStore, sessionStore, emailVerificationStore, twoFactorStore, loginAttemptStore, mail, passwordPolicy)
+	apiHandler := handlers.NewAPIHandler(messageStore)
+	tokenHandler := handlers.NewTokenHandler(tokenStore)
+	accountHandler := handlers.NewAccountHandler(sessionStore)
+	passwordHandler := handlers.NewPasswordHandler(userStore, sessionStore, passwordResetStore, mail, passwordPolicy)
+	emailHandler := handlers.NewEmailHandler(userStore, emailVerificationStore, mail)
+	twoFactorHandler := handlers.NewTwoFactorHandler(userStore, twoFactorStore)
+	adminHandler := handlers.NewAdminHandler(userStore)
+	reportHandler := handlers.NewReportHandler(reportStore, messageStore, userStore)
 
 	// Echoインスタンスの作成
 	e := echo.New()
 
+	// ログイン試行の制限やセッションの記録に使うIPアドレスを偽装されないよう、
+	// X-Forwarded-For はリバースプロキシの背後で動かす場合（TRUST_PROXY=true）のみ信頼する
+	if os.Getenv("TRUST_PROXY") == "true" {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	} else {
+		e.IPExtractor = echo.ExtractIPDirect()
+	}
+
+	// ハンドラーが返したエラーはエラーの種類に応じたステータスコードで、画面またはJSONとして返す
+	e.HTTPErrorHandler = handlers.ErrorHandler
+
 	// ミドルウェア
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
@@ -55,22 +112,130 @@ func main() {
 	// パブリックルート
 	e.GET("/login", authHandler.ShowLoginPage)
 	e.POST("/login", authHandler.Login)
+	e.GET("/login/2fa", authHandler.ShowTwoFactorPage)
+	e.POST("/login/2fa", authHandler.VerifyTwoFactor)
+
+	// OpenID Connect によるシングルサインオン（OIDC_ISSUER_URL が設定されている場合のみ）
+	if issuer := os.Getenv("OIDC_ISSUER_URL"); issuer != "" {
+		redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+		if redirectURL == "" {
+			redirectURL = "http://localhost:8080/login/oidc/callback"
+		}
+		provider := oidc.NewProvider(oidc.Config{
+			IssuerURL:    issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  redirectURL,
+		}, nil)
+		oidcHandler := handlers.NewOIDCHandler(provider, userStore, identityStore, authHandler)
+		e.GET("/login/oidc", oidcHandler.Start)
+		e.GET("/login/oidc/callback", oidcHandler.Callback)
+	}
 	e.GET("/register", authHandler.ShowRegisterPage)
 	e.POST("/register", authHandler.Register)
+	e.GET("/password/forgot", passwordHandler.ShowForgotPage)
+	e.POST("/password/forgot", passwordHandler.RequestReset)
+	e.GET("/password/reset", passwordHandler.ShowResetPage)
+	e.POST("/password/reset", passwordHandler.ResetPassword)
+	e.GET("/verify-email", emailHandler.VerifyEmail)
+	e.POST("/api/v1/auth/token", authHandler.IssueToken)
+	e.POST("/api/v1/auth/refresh", authHandler.RefreshToken)
 
 	// 認証が必要なルートグループ
+	// パーソナルアクセストークンはルートごとのスコープでチェックする
+	read := handlers.RequireScope(models.ScopeRead)
+	write := handlers.RequireScope(models.ScopeWrite)
+	admin := handlers.RequireScope(models.ScopeAdmin)
+
+	// REQUIRE_VERIFIED_EMAIL=true の場合、新規投稿にはメールアドレスの確認が必要
+	posting := []echo.MiddlewareFunc{write}
+	if os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true" {
+		posting = append(posting, handlers.RequireVerifiedEmail(userStore))
+	}
+
 	auth := e.Group("")
-	auth.Use(handlers.JWTMiddleware)
-
-	auth.GET("/", messageHandler.ListMessages)
-	auth.POST("/messages", messageHandler.CreateMessage)
-	auth.GET("/search", messageHandler.SearchMessages)
-	auth.GET("/messages/:id", messageHandler.GetMessage)
-	auth.POST("/messages/:id", messageHandler.UpdateMessage)
-	auth.GET("/messages/:id/edit", messageHandler.EditMessage)
-	auth.POST("/messages/:id/delete", messageHandler.DeleteMessage)
+	auth.Use(authHandler.JWTMiddleware)
+
+	auth.GET("/", messageHandler.ListMessages, read)
+	auth.POST("/messages", messageHandler.CreateMessage, posting...)
+	auth.GET("/search", messageHandler.SearchMessages, read)
+	auth.GET("/messages/:id", messageHandler.GetMessage, read)
+	auth.POST("/messages/:id", messageHandler.UpdateMessage, write)
+	auth.GET("/messages/:id/history", messageHandler.MessageHistory, read)
+	auth.GET("/messages/:id/edit", messageHandler.EditMessage, write)
+	auth.POST("/messages/:id/delete", messageHandler.DeleteMessage, write)
+	auth.POST("/messages/:id/restore", messageHandler.RestoreMessage, write)
+	auth.GET("/trash", messageHandler.ListTrash, read)
+	auth.POST("/messages/:id/report", reportHandler.Report, write)
+	auth.POST("/messages/:id/posts", postHandler.CreatePost, posting...)
+	auth.GET("/messages/:id/posts/:post_id/edit", postHandler.EditPost, write)
+	auth.POST("/messages/:id/posts/:post_id", postHandler.UpdatePost, write)
+	auth.POST("/messages/:id/posts/:post_id/delete", postHandler.DeletePost, write)
+	auth.GET("/settings/tokens", tokenHandler.ListTokens, admin)
+	auth.POST("/settings/tokens", tokenHandler.CreateToken, admin)
+	auth.POST("/settings/tokens/:id/revoke", tokenHandler.RevokeToken, admin)
+	auth.GET("/account/sessions", accountHandler.ListSessions, admin)
+	auth.POST("/account/sessions/:id/revoke", accountHandler.RevokeSession, admin)
+	auth.GET("/account/email", emailHandler.ShowEmailPage, admin)
+	auth.POST("/account/email", emailHandler.UpdateEmail, admin)
+	auth.POST("/account/email/resend", emailHandler.ResendVerification, admin)
+	auth.GET("/account/2fa", twoFactorHandler.ShowTwoFactorPage, admin)
+	auth.POST("/account/2fa/setup", twoFactorHandler.BeginSetup, admin)
+	auth.POST("/account/2fa/enable", twoFactorHandler.Enable, admin)
+	auth.POST("/account/2fa/disable", twoFactorHandler.Disable, admin)
+	auth.GET("/account/password", passwordHandler.ShowChangePage, admin)
+	auth.POST("/account/password", passwordHandler.ChangePassword, admin)
 	auth.POST("/logout", authHandler.Logout)
+	auth.POST("/logout/all", authHandler.LogoutAll, admin)
+
+	// 管理画面（管理者の役割が必要）
+	manage := e.Group("/admin")
+	manage.Use(authHandler.JWTMiddleware, admin, handlers.RequirePermission(models.PermissionManageUsers))
+
+	manage.GET("", func(c echo.Context) error {
+		return c.Redirect(http.StatusSeeOther, "/admin/users")
+	})
+	manage.GET("/users", adminHandler.ListUsers)
+	manage.POST("/users/:id/suspend", adminHandler.Suspend)
+	manage.POST("/users/:id/unsuspend", adminHandler.Unsuspend)
+	manage.POST("/users/:id/role", adminHandler.ChangeRole)
+	manage.POST("/users/:id/reset-password", adminHandler.ResetPassword)
+	manage.POST("/users/:id/delete", adminHandler.DeleteUser)
+
+	// 通報の対応（モデレーターの役割が必要）
+	moderation := e.Group("/moderation")
+	moderation.Use(authHandler.JWTMiddleware, admin, handlers.RequirePermission(models.PermissionModerateContent))
+
+	moderation.GET("/reports", reportHandler.Queue)
+	moderation.POST("/reports/:id/resolve", reportHandler.Resolve)
+	moderation.POST("/messages/:id/unhide", reportHandler.Unhide)
+
+	// JSON API
+	api := e.Group("/api/v1")
+	api.Use(authHandler.JWTMiddleware)
+
+	api.GET("/messages", apiHandler.ListMessages, read)
+	api.POST("/messages", apiHandler.CreateMessage, posting...)
+	api.GET("/messages/search", apiHandler.SearchMessages, read)
+	api.GET("/messages/:id", apiHandler.GetMessage, read)
+	api.PUT("/messages/:id", apiHandler.UpdateMessage, write)
+	api.DELETE("/messages/:id", apiHandler.DeleteMessage, write)
 
 	// サーバーの起動
 	e.Logger.Fatal(e.Start(":8080"))
 }
+
+// purgeTrash は保存期間を過ぎたゴミ箱のメッセージを定期的に完全に削除する
+func purgeTrash(store *models.MessageStore, retentionDays int) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		purged, err := store.Purge(time.Now().AddDate(0, 0, -retentionDays))
+		if err != nil {
+			log.Println("ゴミ箱の完全削除に失敗しました:", err)
+		} else if purged > 0 {
+			log.Printf("ゴミ箱のメッセージを%d件完全に削除しました", purged)
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,139 @@
+// Package diff は2つの文章の単語単位の差分を求める。
+// 分かち書きされない日本語（漢字・ひらがな・カタカナ）やハングルは1文字を1単語として扱う。
+package diff
+
+import "unicode"
+
+// Kind は差分の種類
+type Kind string
+
+const (
+	Equal  Kind = "equal"
+	Insert Kind = "insert"
+	Delete Kind = "delete"
+)
+
+// Op は差分の1区間。同じ種類の連続する単語はまとめられる。
+type Op struct {
+	Kind Kind   `json:"kind"`
+	Text string `json:"text"`
+}
+
+// Words は a から b への単語単位の差分を返す。
+// 最長共通部分列を求め、a にだけある単語を Delete、b にだけある単語を Insert とする。
+func Words(a, b string) []Op {
+	x, y := Tokenize(a), Tokenize(b)
+
+	// 共通の先頭と末尾は比較の対象から外す
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	var ops []Op
+	for _, t := range x[:prefix] {
+		ops = appendOp(ops, Equal, t)
+	}
+	ops = append(ops, lcs(x[prefix:len(x)-suffix], y[prefix:len(y)-suffix])...)
+	for _, t := range x[len(x)-suffix:] {
+		ops = appendOp(ops, Equal, t)
+	}
+	return merge(ops)
+}
+
+// lcs は動的計画法で最長共通部分列を求め、差分を返す
+func lcs(x, y []string) []Op {
+	n, m := len(x), len(y)
+	// table[i][j] は x[i:] と y[j:] の最長共通部分列の長さ
+	table := make([][]int, n+1)
+	for i := range table {
+		table[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				table[i][j] = table[i+1][j+1] + 1
+			} else if table[i+1][j] >= table[i][j+1] {
+				table[i][j] = table[i+1][j]
+			} else {
+				table[i][j] = table[i][j+1]
+			}
+		}
+	}
+
+	var ops []Op
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case x[i] == y[j]:
+			ops = appendOp(ops, Equal, x[i])
+			i++
+			j++
+		case table[i+1][j] >= table[i][j+1]:
+			ops = appendOp(ops, Delete, x[i])
+			i++
+		default:
+			ops = appendOp(ops, Insert, y[j])
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = appendOp(ops, Delete, x[i])
+	}
+	for ; j < m; j++ {
+		ops = appendOp(ops, Insert, y[j])
+	}
+	return ops
+}
+
+func appendOp(ops []Op, kind Kind, text string) []Op {
+	if len(ops) > 0 && ops[len(ops)-1].Kind == kind {
+		ops[len(ops)-1].Text += text
+		return ops
+	}
+	return append(ops, Op{Kind: kind, Text: text})
+}
+
+// merge は同じ種類の隣り合う区間をまとめる
+func merge(ops []Op) []Op {
+	var result []Op
+	for _, op := range ops {
+		result = appendOp(result, op.Kind, op.Text)
+	}
+	return result
+}
+
+// Tokenize は文章を単語に分割する。連続する英数字と連続する空白はそれぞれ1単語、
+// 日本語やハングルの文字と記号は1文字ずつ1単語とする。分割した単語をつなげると元の文章に戻る。
+func Tokenize(s string) []string {
+	var tokens []string
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		j := i + 1
+		switch {
+		case isWordRune(runes[i]):
+			for j < len(runes) && isWordRune(runes[j]) {
+				j++
+			}
+		case unicode.IsSpace(runes[i]):
+			for j < len(runes) && unicode.IsSpace(runes[j]) {
+				j++
+			}
+		}
+		tokens = append(tokens, string(runes[i:j]))
+		i = j
+	}
+	return tokens
+}
+
+// isWordRune は r が単語を構成する文字（分かち書きされない文字を除く英数字）かを返す
+func isWordRune(r rune) bool {
+	if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+		return false
+	}
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
@@ -0,0 +1,106 @@
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"hello world", []string{"hello", " ", "world"}},
+		{"今日は晴れ", []string{"今", "日", "は", "晴", "れ"}},
+		{"Go言語 v1.23", []string{"Go", "言", "語", " ", "v1", ".", "23"}},
+		{"カタカナ、テスト", []string{"カ", "タ", "カ", "ナ", "、", "テ", "ス", "ト"}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		got := Tokenize(tt.input)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%qの分割結果が%qであるべきですが、実際は%qです", tt.input, tt.want, got)
+		}
+		if strings.Join(got, "") != tt.input {
+			t.Errorf("%qの分割結果をつなげても元の文章に戻りません", tt.input)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []Op
+	}{
+		{
+			name: "同じ文章",
+			a:    "hello world",
+			b:    "hello world",
+			want: []Op{{Equal, "hello world"}},
+		},
+		{
+			name: "単語の置き換え",
+			a:    "the quick fox",
+			b:    "the slow fox",
+			want: []Op{{Equal, "the "}, {Delete, "quick"}, {Insert, "slow"}, {Equal, " fox"}},
+		},
+		{
+			name: "単語の一部が変わっても単語ごと置き換える",
+			a:    "version 1",
+			b:    "versions 1",
+			want: []Op{{Delete, "version"}, {Insert, "versions"}, {Equal, " 1"}},
+		},
+		{
+			name: "日本語は1文字ずつ比較する",
+			a:    "今日は晴れです",
+			b:    "今日は雨です",
+			want: []Op{{Equal, "今日は"}, {Delete, "晴れ"}, {Insert, "雨"}, {Equal, "です"}},
+		},
+		{
+			name: "追加のみ",
+			a:    "",
+			b:    "新しい",
+			want: []Op{{Insert, "新しい"}},
+		},
+		{
+			name: "削除のみ",
+			a:    "古い内容",
+			b:    "",
+			want: []Op{{Delete, "古い内容"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Words(tt.a, tt.b)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("差分が%vであるべきですが、実際は%vです", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWords_Reconstruct(t *testing.T) {
+	a := "スレッドへの投稿です。 Hello, world!"
+	b := "スレッドへの返信です。 Hello, Go world!!"
+
+	// Equal と Delete をつなげると a、Equal と Insert をつなげると b に戻る
+	var gotA, gotB strings.Builder
+	for _, op := range Words(a, b) {
+		if op.Kind != Insert {
+			gotA.WriteString(op.Text)
+		}
+		if op.Kind != Delete {
+			gotB.WriteString(op.Text)
+		}
+	}
+	if gotA.String() != a {
+		t.Errorf("変更前の文章に戻りません: %q", gotA.String())
+	}
+	if gotB.String() != b {
+		t.Errorf("変更後の文章に戻りません: %q", gotB.String())
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"message-board/internal/models"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/labstack/echo/v4"
+)
+
+// AccountHandler はアカウント設定ページを提供する
+type AccountHandler struct {
+	sessionStore *models.SessionStore
+}
+
+func NewAccountHandler(sessionStore *models.SessionStore) *AccountHandler {
+	return &AccountHandler{sessionStore: sessionStore}
+}
+
+// sessionView はセッション一覧の1行分の表示内容
+type sessionView struct {
+	models.Session
+	Device  string
+	Current bool
+}
+
+// ListSessions はログイン中の端末の一覧を表示する
+func (h *AccountHandler) ListSessions(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	currentID, _ := c.Get("session_id").(string)
+
+	sessions, err := h.sessionStore.List(userID)
+	if err != nil {
+		return errorPage(err, "システムエラー", "セッションの取得中にエラーが発生しました。", "/")
+	}
+
+	views := make([]sessionView, 0, len(sessions))
+	for _, session := range sessions {
+		views = append(views, sessionView{
+			Session: session,
+			Device:  describeUserAgent(session.UserAgent),
+			Current: session.ID == currentID,
+		})
+	}
+
+	tpl := pongo2.Must(pongo2.FromFile("templates/sessions.html"))
+	return tpl.ExecuteWriter(pongo2.Context{
+		"sessions": views,
+		"user_id":  userID,
+		"username": c.Get("username").(string),
+	}, c.Response().Writer)
+}
+
+// RevokeSession は指定したセッションを失効させ、その端末をログアウトさせる
+func (h *AccountHandler) RevokeSession(c echo.Context) error {
+	id := c.Param("id")
+	userID := c.Get("user_id").(int)
+
+	if err := h.sessionStore.RevokeForUser(id, userID); err != nil {
+		return errorPage(err, "セッションが見つかりません", "指定されたセッションは存在しないか、すでにログアウトしています。", "/account/sessions")
+	}
+
+	// 現在の端末を失効させた場合はログアウトする
+	if currentID, _ := c.Get("session_id").(string); currentID == id {
+		clearAuthCookies(c)
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/account/sessions")
+}
+
+// describeUserAgent は User-Agent からブラウザとOSのおおまかな名前を返す
+func describeUserAgent(ua string) string {
+	if ua == "" {
+		return "不明な端末"
+	}
+
+	browser := ""
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	}
+
+	os := ""
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		os = "iOS"
+	case strings.Contains(ua, "Android"):
+		os = "Android"
+	case strings.Contains(ua, "Windows"):
+		os = "Windows"
+	case strings.Contains(ua, "Mac OS X"):
+		os = "macOS"
+	case strings.Contains(ua, "Linux"):
+		os = "Linux"
+	}
+
+	switch {
+	case browser != "" && os != "":
+		return browser + " (" + os + ")"
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	}
+
+	// curl などのクライアントは名前部分のみを表示する
+	name, _, _ := strings.Cut(ua, " ")
+	return name
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"message-board/internal/models"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	adminUsersPerPage       = 20
+	maxSuspensionReasonSize = 200
+)
+
+// 利用停止の期間の選択肢（日数）。0 は無期限。
+var suspensionDays = []int{1, 7, 30, 0}
+
+// 管理画面の操作後に一覧へ表示するメッセージ
+var adminNotices = map[string]string{
+	"suspended":   "ユーザーの利用を停止しました。",
+	"unsuspended": "ユーザーの利用停止を解除しました。",
+	"role":        "ユーザーの役割を変更しました。",
+	"deleted":     "ユーザーを削除しました。",
+}
+
+// AdminHandler は管理者向けのユーザー管理ページを提供する
+type AdminHandler struct {
+	userStore *models.UserStore
+}
+
+func NewAdminHandler(userStore *models.UserStore) *AdminHandler {
+	return &AdminHandler{userStore: userStore}
+}
+
+// ListUsers はユーザーの一覧を投稿数と最終ログイン日時とともに表示する
+func (h *AdminHandler) ListUsers(c echo.Context) error {
+	query := strings.TrimSpace(c.QueryParam("q"))
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	users, total, err := h.userStore.ListSummaries(query, page, adminUsersPerPage)
+	if err != nil {
+		return errorPage(err, "システムエラー", "ユーザーの取得中にエラーが発生しました。", "/")
+	}
+
+	totalPages := (total + adminUsersPerPage - 1) / adminUsersPerPage
+	var params url.Values
+	if query != "" {
+		params = url.Values{"q": {query}}
+	}
+
+	tpl := pongo2.Must(pongo2.FromFile("templates/admin_users.html"))
+	return tpl.ExecuteWriter(pongo2.Context{
+		"users":       users,
+		"roles":       models.AllRoles,
+		"days":        suspensionDays,
+		"query":       query,
+		"total":       total,
+		"page":        page,
+		"page_url":    pageURL("/admin/users", params),
+		"total_pages": totalPages,
+		"has_prev":    page > 1,
+		"has_next":    page < totalPages,
+		"notice":      adminNotices[c.QueryParam("done")],
+		"user_id":     c.Get("user_id").(int),
+		"username":    c.Get("username").(string),
+	}, c.Response().Writer)
+}
+
+// Suspend はユーザーの利用を指定した日数だけ停止する。日数を指定しない場合は無期限に停止する。
+func (h *AdminHandler) Suspend(c echo.Context) error {
+	userID, ok := h.targetUser(c)
+	if !ok {
+		return adminSelfError(c, "自分自身の利用を停止することはできません。")
+	}
+
+	reason := strings.TrimSpace(c.FormValue("reason"))
+	if reason == "" || utf8.RuneCountInString(reason) > maxSuspensionReasonSize {
+		return errorPage(models.ErrValidation, "入力エラー", "利用停止の理由を200文字以内で入力してください。", "/admin/users")
+	}
+
+	var until *time.Time
+	if days, err := strconv.Atoi(c.FormValue("days")); err == nil && days > 0 {
+		t := time.Now().AddDate(0, 0, days)
+		until = &t
+	}
+
+	if err := h.userStore.Suspend(c.Get("user_id").(int), userID, until, reason); err != nil {
+		return adminActionError(c, err)
+	}
+	return c.Redirect(http.StatusSeeOther, "/admin/users?done=suspended")
+}
+
+// Unsuspend はユーザーの利用停止を解除する
+func (h *AdminHandler) Unsuspend(c echo.Context) error {
+	userID, _ := strconv.Atoi(c.Param("id"))
+	if err := h.userStore.Unsuspend(c.Get("user_id").(int), userID); err != nil {
+		return adminActionError(c, err)
+	}
+	return c.Redirect(http.StatusSeeOther, "/admin/users?done=unsuspended")
+}
+
+// ChangeRole はユーザーの役割を変更する
+func (h *AdminHandler) ChangeRole(c echo.Context) error {
+	userID, ok := h.targetUser(c)
+	if !ok {
+		return adminSelfError(c, "自分自身の役割を変更することはできません。")
+	}
+	role, valid := models.ParseRole(c.FormValue("role"))
+	if !valid {
+		return errorPage(models.ErrValidation, "入力エラー", "役割の指定が正しくありません。", "/admin/users")
+	}
+	if err := h.userStore.SetRole(c.Get("user_id").(int), userID, role); err != nil {
+		return adminActionError(c, err)
+	}
+	return c.Redirect(http.StatusSeeOther, "/admin/users?done=role")
+}
+
+// ResetPassword はユーザーのパスワードを仮のパスワードに置き換え、一度だけ表示する
+func (h *AdminHandler) ResetPassword(c echo.Context) error {
+	userID, _ := strconv.Atoi(c.Param("id"))
+	user, err := h.userStore.GetByID(userID)
+	if err != nil {
+		return adminActionError(c, err)
+	}
+
+	password, err := h.userStore.ResetPassword(c.Get("user_id").(int), userID)
+	if err != nil {
+		return adminActionError(c, err)
+	}
+
+	tpl := pongo2.Must(pongo2.FromFile("templates/admin_password.html"))
+	return tpl.ExecuteWriter(pongo2.Context{
+		"target":             user,
+		"temporary_password": password,
+		"user_id":            c.Get("user_id").(int),
+		"username":           c.Get("username").(string),
+	}, c.Response().Writer)
+}
+
+// DeleteUser はユーザーを削除する。スレッドと投稿は mode に応じて匿名化するか削除する。
+func (h *AdminHandler) DeleteUser(c echo.Context) error {
+	userID, ok := h.targetUser(c)
+	if !ok {
+		return adminSelfError(c, "自分自身を削除することはできません。")
+	}
+
+	purge := c.FormValue("mode") == "purge"
+	if err := h.userStore.Delete(c.Get("user_id").(int), userID, purge); err != nil {
+		return adminActionError(c, err)
+	}
+	return c.Redirect(http.StatusSeeOther, "/admin/users?done=deleted")
+}
+
+// targetUser は操作対象のユーザーIDを返す。管理者が締め出されないよう、自分自身の場合は false を返す。
+func (h *AdminHandler) targetUser(c echo.Context) (int, bool) {
+	userID, _ := strconv.Atoi(c.Param("id"))
+	return userID, userID != c.Get("user_id").(int)
+}
+
+func adminSelfError(c echo.Context, message string) error {
+	return errorPage(models.ErrForbidden, "操作エラー", message, "/admin/users")
+}
+
+func adminActionError(c echo.Context, err error) error {
+	if errors.Is(err, models.ErrNotFound) {
+		return errorPage(err, "ユーザーが見つかりません", "指定されたユーザーは存在しません。", "/admin/users")
+	}
+	return errorPage(err, "システムエラー", "ユーザーの更新中にエラーが発生しました。", "/admin/users")
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"message-board/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+const maxAPIPerPage = 100
+
+// messageRequest は作成・更新APIのリクエストボディ
+type messageRequest struct {
+	Title   string `json:"title" form:"title"`
+	Content string `json:"content" form:"content"`
+}
+
+// APIHandler は /api/v1 以下のJSON APIを提供する
+type APIHandler struct {
+	store *models.MessageStore
+}
+
+func NewAPIHandler(store *models.MessageStore) *APIHandler {
+	return &APIHandler{store: store}
+}
+
+func (h *APIHandler) ListMessages(c echo.Context) error {
+	page, limit := apiPaging(c)
+
+	messages, total, err := h.store.List(page, limit)
+	if err != nil {
+		return errorPage(err, "システムエラー", "メッセージの取得中にエラーが発生しました。", "/")
+	}
+
+	return c.JSON(http.StatusOK, messageListResponse(messages, page, limit, total))
+}
+
+func (h *APIHandler) GetMessage(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return messageAPIError(models.ErrNotFound, "", "")
+	}
+
+	message, err := h.store.Get(id)
+	if err != nil {
+		return messageAPIError(err, "", "メッセージの取得中にエラーが発生しました。")
+	}
+	// 非表示のメッセージは投稿者本人とモデレーターのみ閲覧できる
+	if message.HiddenAt != nil && !canModify(c, message.UserID) {
+		return messageAPIError(models.ErrNotFound, "", "")
+	}
+
+	setETag(c, message)
+	return c.JSON(http.StatusOK, message)
+}
+
+func (h *APIHandler) CreateMessage(c echo.Context) error {
+	var req messageRequest
+	if err := c.Bind(&req); err != nil {
+		return apiError(c, http.StatusBadRequest, "リクエストの形式が正しくありません。")
+	}
+
+	title, content, msg := validateMessageRequest(req)
+	if msg != "" {
+		return apiError(c, http.StatusUnprocessableEntity, msg)
+	}
+
+	// 現在のユーザーIDを取得
+	userID := c.Get("user_id").(int)
+
+	id, err := h.store.Create(title, content, userID)
+	if err != nil {
+		return errorPage(err, "システムエラー", "メッセージの作成中にエラーが発生しました。", "/")
+	}
+
+	message, err := h.store.Get(id)
+	if err != nil {
+		return messageAPIError(err, "", "メッセージの取得中にエラーが発生しました。")
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, "/api/v1/messages/"+strconv.Itoa(id))
+	setETag(c, message)
+	return c.JSON(http.StatusCreated, message)
+}
+
+// UpdateMessage はメッセージを更新する。他の更新を上書きしないよう、取得時の ETag を If-Match ヘッダーで送る必要がある。
+func (h *APIHandler) UpdateMessage(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return messageAPIError(models.ErrNotFound, "", "")
+	}
+
+	ifMatch := c.Request().Header.Get("If-Match")
+	if ifMatch == "" {
+		return apiError(c, http.StatusPreconditionRequired, "If-Match ヘッダーにメッセージの ETag を指定してください。")
+	}
+
+	var req messageRequest
+	if err := c.Bind(&req); err != nil {
+		return apiError(c, http.StatusBadRequest, "リクエストの形式が正しくありません。")
+	}
+
+	// 存在と権限を先にチェック
+	message, err := h.store.Get(id)
+	if err != nil {
+		return messageAPIError(err, "", "メッセージの取得中にエラーが発生しました。")
+	}
+
+	userID := c.Get("user_id").(int)
+	if !canModify(c, message.UserID) {
+		return messageAPIError(models.ErrForbidden, "自分のメッセージのみ編集できます。", "")
+	}
+
+	title, content, msg := validateMessageRequest(req)
+	if msg != "" {
+		return apiError(c, http.StatusUnprocessableEntity, msg)
+	}
+
+	version, ok := parseETag(ifMatch)
+	if ifMatch == "*" {
+		version, ok = message.Version, true
+	}
+	if !ok {
+		return versionConflict(c, message)
+	}
+
+	if err := h.store.Update(id, title, content, userID, version); err != nil {
+		if errors.Is(err, models.ErrConflict) {
+			// 最新の版を返し、クライアントが変更を確認してやり直せるようにする
+			if message, err = h.store.Get(id); err != nil {
+				return messageAPIError(err, "", "メッセージの取得中にエラーが発生しました。")
+			}
+			return versionConflict(c, message)
+		}
+		return messageAPIError(err, "自分のメッセージのみ編集できます。", "メッセージの更新中にエラーが発生しました。")
+	}
+
+	message, err = h.store.Get(id)
+	if err != nil {
+		return messageAPIError(err, "", "メッセージの取得中にエラーが発生しました。")
+	}
+
+	setETag(c, message)
+	return c.JSON(http.StatusOK, message)
+}
+
+func (h *APIHandler) DeleteMessage(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return messageAPIError(models.ErrNotFound, "", "")
+	}
+
+	// 存在と権限を先にチェック
+	message, err := h.store.Get(id)
+	if err != nil {
+		return messageAPIError(err, "", "メッセージの取得中にエラーが発生しました。")
+	}
+
+	userID := c.Get("user_id").(int)
+	if !canModify(c, message.UserID) {
+		return messageAPIError(models.ErrForbidden, "自分のメッセージのみ削除できます。", "")
+	}
+
+	if err := h.store.Delete(id, userID); err != nil {
+		return messageAPIError(err, "自分のメッセージのみ削除できます。", "メッセージの削除中にエラーが発生しました。")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *APIHandler) SearchMessages(c echo.Context) error {
+	opts, _, err := parseSearchOptions(c)
+	if err != nil {
+		return apiError(c, http.StatusUnprocessableEntity, "検索条件が正しくありません。日付はYYYY-MM-DD形式で指定してください。")
+	}
+	if opts.IsEmpty() {
+		return apiError(c, http.StatusUnprocessableEntity, "検索語または絞り込み条件を指定してください。")
+	}
+
+	page, limit := apiPaging(c)
+
+	messages, total, err := h.store.Search(opts, page, limit)
+	if err != nil {
+		return errorPage(err, "システムエラー", "メッセージの検索中にエラーが発生しました。", "/")
+	}
+
+	return c.JSON(http.StatusOK, messageListResponse(messages, page, limit, total))
+}
+
+// apiPaging は page と per_page クエリパラメータを読み取る
+func apiPaging(c echo.Context) (int, int) {
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("per_page"))
+	if limit < 1 {
+		limit = perPage
+	}
+	if limit > maxAPIPerPage {
+		limit = maxAPIPerPage
+	}
+	return page, limit
+}
+
+func messageListResponse(messages []models.Message, page, limit, total int) echo.Map {
+	if messages == nil {
+		messages = []models.Message{}
+	}
+	return echo.Map{
+		"messages":    messages,
+		"page":        page,
+		"per_page":    limit,
+		"total":       total,
+		"total_pages": (total + limit - 1) / limit,
+	}
+}
+
+// validateMessageRequest は前後の空白を除いたタイトルと内容を検証し、
+// 問題があればエラーメッセージを返す
+func validateMessageRequest(req messageRequest) (string, string, string) {
+	title := strings.TrimSpace(req.Title)
+	content := strings.TrimSpace(req.Content)
+
+	if len(title) == 0 || len(content) == 0 {
+		return title, content, "タイトルと内容は必須です。"
+	}
+	if utf8.RuneCountInString(title) > maxMsgSize || utf8.RuneCountInString(content) > maxMsgSize {
+		return title, content, "タイトルと内容は20文字以内で入力してください。"
+	}
+	return title, content, ""
+}
+
+// setETag はメッセージの版番号を ETag ヘッダーに設定する
+func setETag(c echo.Context, message *models.Message) {
+	c.Response().Header().Set("ETag", `"`+strconv.Itoa(message.Version)+`"`)
+}
+
+// parseETag は ETag の値から版番号を取り出す。弱い ETag（W/ で始まるもの）も受け付ける。
+func parseETag(value string) (int, bool) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.Atoi(value[1 : len(value)-1])
+	return version, err == nil
+}
+
+// versionConflict は If-Match の ETag が最新の版と一致しない場合のレスポンスを返す。
+// 最新のメッセージとその ETag を含める。
+func versionConflict(c echo.Context, message *models.Message) error {
+	setETag(c, message)
+	return c.JSON(http.StatusPreconditionFailed, echo.Map{
+		"error":   "メッセージは他のユーザーによって更新されています。最新の内容を確認してください。",
+		"current": message,
+	})
+}
+
+// messageAPIError はメッセージの操作で発生したエラーを種類を保ったまま返す。
+// ステータスコードは ErrorHandler がエラーの種類から決め、レスポンスには種類に応じた説明を含める。
+func messageAPIError(err error, forbidden, failed string) error {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		return errorPage(err, "メッセージが見つかりません", "指定されたメッセージは存在しません。", "/")
+	case errors.Is(err, models.ErrForbidden):
+		return errorPage(err, "権限エラー", forbidden, "/")
+	}
+	return errorPage(err, "システムエラー", failed, "/")
+}
+
+func apiError(c echo.Context, status int, message string) error {
+	return c.JSON(status, echo.Map{"error": message})
+}
@@ -1,9 +1,18 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"message-board/internal/mailer"
 	"message-board/internal/models"
+	"message-board/internal/totp"
+	"message-board/internal/validation"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/flosch/pongo2/v6"
@@ -11,119 +20,717 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	// アクセストークン（JWT）の有効期間
+	accessTokenLifetime = 15 * time.Minute
+	// セッションとリフレッシュトークンの有効期間（使われるたびに延長される）
+	sessionLifetime = 30 * 24 * time.Hour
+	// パスワード認証後、2段階認証のコードを入力するまでの猶予
+	twoFactorLoginLifetime = 5 * time.Minute
+)
+
 type AuthHandler struct {
-	userStore *models.UserStore
+	userStore         *models.UserStore
+	tokenStore        *models.TokenStore
+	sessionStore      *models.SessionStore
+	verificationStore *models.EmailVerificationStore
+	twoFactorStore    *models.TwoFactorStore
+	attemptStore      *models.LoginAttemptStore
+	mailer            mailer.Mailer
+	passwordPolicy    *validation.PasswordPolicy
+}
+
+func NewAuthHandler(userStore *models.UserStore, tokenStore *models.TokenStore, sessionStore *models.SessionStore, verificationStore *models.EmailVerificationStore, twoFactorStore *models.TwoFactorStore, attemptStore *models.LoginAttemptStore, m mailer.Mailer, passwordPolicy *validation.PasswordPolicy) *AuthHandler {
+	return &AuthHandler{
+		userStore:         userStore,
+		tokenStore:        tokenStore,
+		sessionStore:      sessionStore,
+		verificationStore: verificationStore,
+		twoFactorStore:    twoFactorStore,
+		attemptStore:      attemptStore,
+		mailer:            m,
+		passwordPolicy:    passwordPolicy,
+	}
 }
 
-func NewAuthHandler(userStore *models.UserStore) *AuthHandler {
-	return &AuthHandler{userStore: userStore}
+// issuedTokens はログインまたはリフレッシュで発行されたトークン
+type issuedTokens struct {
+	accessToken     string
+	accessExpiresAt time.Time
+	// refreshToken が空の場合、リフレッシュトークンは更新されていない
+	refreshToken string
+	session      *models.Session
 }
 
 func (h *AuthHandler) ShowLoginPage(c echo.Context) error {
 	tpl := pongo2.Must(pongo2.FromFile("templates/login.html"))
-	return tpl.ExecuteWriter(pongo2.Context{}, c.Response().Writer)
+	return tpl.ExecuteWriter(pongo2.Context{
+		"reset_done": c.QueryParam("reset") == "done",
+		"sso_name":   ssoName(),
+	}, c.Response().Writer)
 }
 
 func (h *AuthHandler) ShowRegisterPage(c echo.Context) error {
-	tpl := pongo2.Must(pongo2.FromFile("templates/register.html"))
-	return tpl.ExecuteWriter(pongo2.Context{}, c.Response().Writer)
+	return h.renderRegister(c, "", "", validation.Errors{})
 }
 
 func (h *AuthHandler) Login(c echo.Context) error {
 	username := c.FormValue("username")
 	password := c.FormValue("password")
 
+	attempt, wait, err := h.beginLogin(c, username)
+	if err != nil {
+		return errorPage(err, "システムエラー", "ログイン処理中にエラーが発生しました。", "/login")
+	}
+	defer closeLoginAttempt(attempt)
+	if wait > 0 {
+		return errorPage(echo.ErrTooManyRequests, "ログインエラー", throttledMessage(wait), "/login")
+	}
+
 	user, err := h.userStore.Authenticate(username, password)
 	if err != nil {
-		tpl := pongo2.Must(pongo2.FromFile("templates/error.html"))
+		recordLoginFailure(attempt, loginFailureReason(err))
+		var suspendedErr *models.SuspendedError
+		if errors.As(err, &suspendedErr) {
+			return suspended(c, suspendedErr.Suspension)
+		}
+		return errorPage(echo.ErrUnauthorized, "ログインエラー", "ユーザー名またはパスワードが正しくありません。", "/login")
+	}
+
+	return h.loginUser(c, user)
+}
+
+// loginUser は本人確認が済んだユーザーをログインさせる。
+// 2段階認証が有効な場合は、コードの入力が済むまでセッションを発行しない。
+func (h *AuthHandler) loginUser(c echo.Context, user *models.User) error {
+	if user.Suspended() {
+		return suspended(c, user.Suspension)
+	}
+	if user.TwoFactorEnabled {
+		if err := setTwoFactorCookie(c, user.ID); err != nil {
+			return errorPage(err, "システムエラー", "ログイン処理中にエラーが発生しました。", "/login")
+		}
+		return c.Redirect(http.StatusSeeOther, "/login/2fa")
+	}
+
+	return h.completeLogin(c, user)
+}
+
+func (h *AuthHandler) ShowTwoFactorPage(c echo.Context) error {
+	if _, err := twoFactorUserID(c); err != nil {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+	tpl := pongo2.Must(pongo2.FromFile("templates/login_2fa.html"))
+	return tpl.ExecuteWriter(pongo2.Context{}, c.Response().Writer)
+}
+
+// VerifyTwoFactor はログインの2段階目として、認証アプリのコードまたはリカバリーコードを検証する
+func (h *AuthHandler) VerifyTwoFactor(c echo.Context) error {
+	userID, err := twoFactorUserID(c)
+	if err != nil {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	user, err := h.userStore.GetByID(userID)
+	if err != nil {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	// 確認コードの総当たりもパスワードと同じ失敗回数で制限する
+	attempt, wait, err := h.beginLogin(c, user.Username)
+	if err != nil {
+		return errorPage(err, "システムエラー", "ログイン処理中にエラーが発生しました。", "/login")
+	}
+	defer closeLoginAttempt(attempt)
+	if wait > 0 {
+		tpl := pongo2.Must(pongo2.FromFile("templates/login_2fa.html"))
+		return tpl.ExecuteWriter(pongo2.Context{
+			"error": throttledMessage(wait),
+		}, c.Response().Writer)
+	}
+
+	if err := h.verifyTwoFactorCode(userID, c.FormValue("code")); err != nil {
+		recordLoginFailure(attempt, models.LoginFailureInvalidCode)
+		tpl := pongo2.Must(pongo2.FromFile("templates/login_2fa.html"))
 		return tpl.ExecuteWriter(pongo2.Context{
-			"error_title":   "ログインエラー",
-			"error_message": "ユーザー名またはパスワードが正しくありません。",
-			"back_url":      "/login",
+			"error": "確認コードが正しくありません。",
 		}, c.Response().Writer)
 	}
+	clearTwoFactorCookie(c)
+
+	// パスワードの確認後に利用停止された場合に備え、セッションの発行前にユーザーを読み直す
+	user, err = h.userStore.GetByID(userID)
+	if err != nil {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+	if user.Suspended() {
+		recordLoginFailure(attempt, models.LoginFailureSuspended)
+		return suspended(c, user.Suspension)
+	}
+
+	return h.completeLogin(c, user)
+}
+
+// completeLogin はセッションとトークンを発行してログインを完了する
+func (h *AuthHandler) completeLogin(c echo.Context, user *models.User) error {
+	// セッションとトークンの発行
+	tokens, err := h.startSession(c, user)
+	if err != nil {
+		return errorPage(err, "システムエラー", "ログイン処理中にエラーが発生しました。", "/login")
+	}
+
+	setAuthCookies(c, tokens)
+	h.recordLoginSuccess(c, user.Username)
+
+	return c.Redirect(http.StatusSeeOther, "/")
+}
+
+func (h *AuthHandler) Register(c echo.Context) error {
+	username := c.FormValue("username")
+	password := c.FormValue("password")
+	rawEmail := c.FormValue("email")
+
+	errs := validation.Errors{}
+	errs.Add("username", validation.Username(username))
+	errs.Add("password", h.passwordPolicy.Check(password, username))
+	// メールアドレスは任意
+	email, ok := normalizeEmail(rawEmail)
+	if !ok {
+		errs.Add("email", "メールアドレスの形式が正しくありません。")
+	}
+	if errs.Any() {
+		return h.renderRegister(c, username, rawEmail, errs)
+	}
+
+	userID, err := h.userStore.CreateWithEmail(username, password, email)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrUsernameTaken):
+			errs.Add("username", "このユーザー名は既に使用されています。")
+		case errors.Is(err, models.ErrEmailInUse):
+			errs.Add("email", "このメールアドレスは既に使用されています。")
+		default:
+			return errorPage(err, "登録エラー", "ユーザー登録中にエラーが発生しました。", "/register")
+		}
+		return h.renderRegister(c, username, rawEmail, errs)
+	}
+
+	// 確認メールの送信に失敗しても登録は完了させ、アカウントページから再送できるようにする
+	if email != "" {
+		if err := sendVerificationMail(h.verificationStore, h.mailer, userID, username, email); err != nil {
+			log.Printf("確認メールの送信に失敗しました: %v", err)
+		}
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/login")
+}
+
+// renderRegister は登録フォームを入力値と項目ごとのエラーとともに表示する。パスワードは再表示しない。
+func (h *AuthHandler) renderRegister(c echo.Context, username, email string, errs validation.Errors) error {
+	tpl := pongo2.Must(pongo2.FromFile("templates/register.html"))
+	return tpl.ExecuteWriter(pongo2.Context{
+		"username_value":      username,
+		"email_value":         email,
+		"errors":              errs,
+		"username_min_length": validation.MinUsernameLength,
+		"username_max_length": validation.MaxUsernameLength,
+		"password_min_length": h.passwordPolicy.MinLength,
+	}, c.Response().Writer)
+}
+
+func (h *AuthHandler) Logout(c echo.Context) error {
+	// 現在のセッションを失効させる
+	if sessionID, ok := c.Get("session_id").(string); ok {
+		h.sessionStore.Revoke(sessionID)
+	}
+	clearAuthCookies(c)
+
+	return c.Redirect(http.StatusSeeOther, "/login")
+}
+
+// LogoutAll はユーザーのすべてのセッションを失効させ、すべての端末からログアウトする
+func (h *AuthHandler) LogoutAll(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	if err := h.sessionStore.RevokeAll(userID); err != nil {
+		return errorPage(err, "システムエラー", "ログアウト処理中にエラーが発生しました。", "/")
+	}
+	clearAuthCookies(c)
+
+	return c.Redirect(http.StatusSeeOther, "/login")
+}
+
+// IssueToken はユーザー名とパスワードを検証し、APIクライアント用のアクセストークンとリフレッシュトークンを発行する
+func (h *AuthHandler) IssueToken(c echo.Context) error {
+	var req struct {
+		Username string `json:"username" form:"username"`
+		Password string `json:"password" form:"password"`
+		// OTP は2段階認証が有効なユーザーの確認コードまたはリカバリーコード
+		OTP string `json:"otp" form:"otp"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return apiError(c, http.StatusBadRequest, "リクエストの形式が正しくありません。")
+	}
+
+	attempt, wait, err := h.beginLogin(c, req.Username)
+	if err != nil {
+		log.Printf("ログイン試行の確認に失敗しました: %v", err)
+		return apiError(c, http.StatusInternalServerError, "ログイン処理中にエラーが発生しました。")
+	}
+	defer closeLoginAttempt(attempt)
+	if wait > 0 {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return apiError(c, http.StatusTooManyRequests, throttledMessage(wait))
+	}
+
+	user, err := h.userStore.Authenticate(req.Username, req.Password)
+	if err != nil {
+		recordLoginFailure(attempt, loginFailureReason(err))
+		var suspendedErr *models.SuspendedError
+		if errors.As(err, &suspendedErr) {
+			return suspended(c, suspendedErr.Suspension)
+		}
+		return apiError(c, http.StatusUnauthorized, "ユーザー名またはパスワードが正しくありません。")
+	}
+	if user.TwoFactorEnabled {
+		if req.OTP == "" {
+			return apiError(c, http.StatusUnauthorized, "2段階認証の確認コード（otp）が必要です。")
+		}
+		if err := h.verifyTwoFactorCode(user.ID, req.OTP); err != nil {
+			recordLoginFailure(attempt, models.LoginFailureInvalidCode)
+			return apiError(c, http.StatusUnauthorized, "確認コードが正しくありません。")
+		}
+	}
+
+	tokens, err := h.startSession(c, user)
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, "トークンの発行中にエラーが発生しました。")
+	}
+	h.recordLoginSuccess(c, user.Username)
+
+	return c.JSON(http.StatusOK, tokenResponse(tokens))
+}
+
+// RefreshToken はリフレッシュトークンを新しいアクセストークンとリフレッシュトークンに交換する
+func (h *AuthHandler) RefreshToken(c echo.Context) error {
+	var req struct {
+		RefreshToken string `json:"refresh_token" form:"refresh_token"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return apiError(c, http.StatusBadRequest, "リクエストの形式が正しくありません。")
+	}
+
+	tokens, err := h.refreshSession(req.RefreshToken)
+	if err != nil || tokens.refreshToken == "" {
+		return apiError(c, http.StatusUnauthorized, "リフレッシュトークンが無効です。")
+	}
+	if tokens.session.Suspension != nil {
+		return suspended(c, tokens.session.Suspension)
+	}
+
+	return c.JSON(http.StatusOK, tokenResponse(tokens))
+}
+
+// startSession はリクエスト元の端末情報を記録した新しいセッションを作成し、トークンを発行する
+func (h *AuthHandler) startSession(c echo.Context, user *models.User) (*issuedTokens, error) {
+	userAgent := c.Request().UserAgent()
+	session, refreshToken, err := h.sessionStore.Create(user.ID, userAgent, c.RealIP(), sessionLifetime)
+	if err != nil {
+		return nil, err
+	}
+	return issueAccessToken(session, refreshToken)
+}
+
+// beginLogin はログインの試行を開始し、ユーザー名とリクエスト元IPの失敗から次に試行できるまでの待ち時間を返す。
+// 待ち時間中の場合、試行は記録済みで終了している。試行は呼び出し側で closeLoginAttempt する。
+func (h *AuthHandler) beginLogin(c echo.Context, username string) (*models.LoginAttempt, time.Duration, error) {
+	return h.attemptStore.Begin(username, c.RealIP(), c.Request().UserAgent())
+}
+
+func recordLoginFailure(attempt *models.LoginAttempt, reason string) {
+	if err := attempt.Fail(reason); err != nil {
+		log.Printf("ログイン失敗の記録に失敗しました: %v", err)
+	}
+}
+
+// closeLoginAttempt は失敗を記録しなかった試行を終了する
+func closeLoginAttempt(attempt *models.LoginAttempt) {
+	if err := attempt.Close(); err != nil {
+		log.Printf("ログイン試行の終了に失敗しました: %v", err)
+	}
+}
+
+func (h *AuthHandler) recordLoginSuccess(c echo.Context, username string) {
+	if err := h.attemptStore.RecordSuccess(username, c.RealIP(), c.Request().UserAgent()); err != nil {
+		log.Printf("ログイン成功の記録に失敗しました: %v", err)
+	}
+}
+
+// loginFailureReason は Authenticate のエラーを監査用の失敗理由に変換する
+func loginFailureReason(err error) string {
+	var suspendedErr *models.SuspendedError
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		return models.LoginFailureUnknownUser
+	case errors.As(err, &suspendedErr):
+		return models.LoginFailureSuspended
+	}
+	return models.LoginFailureInvalidPassword
+}
+
+// throttledMessage は待ち時間をユーザー向けのメッセージにする
+func throttledMessage(wait time.Duration) string {
+	if wait < time.Minute {
+		return fmt.Sprintf("ログインの試行回数が多すぎます。%d秒後にもう一度お試しください。", int(math.Ceil(wait.Seconds())))
+	}
+	return fmt.Sprintf("ログインの試行回数が多すぎます。%d分後にもう一度お試しください。", int(math.Ceil(wait.Minutes())))
+}
+
+// verifyTwoFactorCode は認証アプリのコードを検証し、コードの形式でない場合はリカバリーコードとして扱う
+func (h *AuthHandler) verifyTwoFactorCode(userID int, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		return h.twoFactorStore.Verify(userID, code)
+	}
+	return h.twoFactorStore.UseRecoveryCode(userID, code)
+}
+
+// refreshSession はリフレッシュトークンを交換し、新しいアクセストークンを発行する
+func (h *AuthHandler) refreshSession(refreshToken string) (*issuedTokens, error) {
+	session, newRefreshToken, err := h.sessionStore.Rotate(refreshToken, sessionLifetime)
+	if err != nil {
+		return nil, err
+	}
+	return issueAccessToken(session, newRefreshToken)
+}
+
+func issueAccessToken(session *models.Session, refreshToken string) (*issuedTokens, error) {
+	t, expiresAt, err := generateToken(session.UserID, session.Username, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &issuedTokens{
+		accessToken:     t,
+		accessExpiresAt: expiresAt,
+		refreshToken:    refreshToken,
+		session:         session,
+	}, nil
+}
+
+func tokenResponse(tokens *issuedTokens) echo.Map {
+	return echo.Map{
+		"access_token":  tokens.accessToken,
+		"refresh_token": tokens.refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(time.Until(tokens.accessExpiresAt).Seconds()),
+	}
+}
+
+// generateToken はセッションに紐づくJWTアクセストークンと有効期限を生成する
+func generateToken(userID int, username, sessionID string) (string, time.Time, error) {
+	expiresAt := time.Now().Add(accessTokenLifetime)
 
 	// JWTトークンの作成
 	token := jwt.New(jwt.SigningMethodHS256)
 
 	// クレームの設定
 	claims := token.Claims.(jwt.MapClaims)
-	claims["user_id"] = user.ID
-	claims["username"] = user.Username
-	claims["exp"] = time.Now().Add(time.Hour * 72).Unix()
+	claims["user_id"] = userID
+	claims["username"] = username
+	claims["sid"] = sessionID
+	claims["exp"] = expiresAt.Unix()
 
 	// トークンの生成
 	t, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
-	if err != nil {
-		tpl := pongo2.Must(pongo2.FromFile("templates/error.html"))
-		return tpl.ExecuteWriter(pongo2.Context{
-			"error_title":   "システムエラー",
-			"error_message": "ログイン処理中にエラーが発生しました。",
-			"back_url":      "/login",
-		}, c.Response().Writer)
-	}
+	return t, expiresAt, err
+}
 
-	// クッキーの設定
+// setAuthCookies はアクセストークンとリフレッシュトークンをクッキーに設定する
+func setAuthCookies(c echo.Context, tokens *issuedTokens) {
 	cookie := new(http.Cookie)
 	cookie.Name = "token"
-	cookie.Value = t
-	cookie.Expires = time.Now().Add(72 * time.Hour)
+	cookie.Value = tokens.accessToken
+	cookie.Expires = tokens.accessExpiresAt
 	cookie.Path = "/"
+	cookie.HttpOnly = true
+	cookie.SameSite = http.SameSiteLaxMode
 	c.SetCookie(cookie)
 
-	return c.Redirect(http.StatusSeeOther, "/")
+	if tokens.refreshToken == "" {
+		return
+	}
+	refresh := new(http.Cookie)
+	refresh.Name = "refresh_token"
+	refresh.Value = tokens.refreshToken
+	refresh.Expires = tokens.session.ExpiresAt
+	refresh.Path = "/"
+	refresh.HttpOnly = true
+	refresh.SameSite = http.SameSiteLaxMode
+	c.SetCookie(refresh)
 }
 
-func (h *AuthHandler) Register(c echo.Context) error {
-	username := c.FormValue("username")
-	password := c.FormValue("password")
+// setTwoFactorCookie はパスワード認証を通過したユーザーを示す短命の署名付きクッキーを設定する
+func setTwoFactorCookie(c echo.Context, userID int) error {
+	expiresAt := time.Now().Add(twoFactorLoginLifetime)
 
-	if err := h.userStore.Create(username, password); err != nil {
-		tpl := pongo2.Must(pongo2.FromFile("templates/error.html"))
-		return tpl.ExecuteWriter(pongo2.Context{
-			"error_title":   "登録エラー",
-			"error_message": "ユーザー登録に失敗しました。ユーザー名が既に使用されている可能性があります。",
-			"back_url":      "/register",
-		}, c.Response().Writer)
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["user_id"] = userID
+	claims["purpose"] = "2fa"
+	claims["exp"] = expiresAt.Unix()
+
+	t, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		return err
 	}
 
-	return c.Redirect(http.StatusSeeOther, "/login")
+	cookie := new(http.Cookie)
+	cookie.Name = "2fa_token"
+	cookie.Value = t
+	cookie.Expires = expiresAt
+	cookie.Path = "/login"
+	cookie.HttpOnly = true
+	cookie.SameSite = http.SameSiteLaxMode
+	c.SetCookie(cookie)
+	return nil
 }
 
-func (h *AuthHandler) Logout(c echo.Context) error {
+// twoFactorUserID は2段階認証待ちのクッキーを検証し、ユーザーIDを返す
+func twoFactorUserID(c echo.Context) (int, error) {
+	cookie, err := c.Cookie("2fa_token")
+	if err != nil {
+		return 0, err
+	}
+
+	token, err := jwt.Parse(cookie.Value, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return []byte(os.Getenv("JWT_SECRET")), nil
+	})
+	if err != nil || !token.Valid {
+		return 0, errors.New("invalid two-factor token")
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	userID, ok := claims["user_id"].(float64)
+	if !ok || claims["purpose"] != "2fa" {
+		return 0, errors.New("invalid two-factor token")
+	}
+	return int(userID), nil
+}
+
+func clearTwoFactorCookie(c echo.Context) {
 	cookie := new(http.Cookie)
-	cookie.Name = "token"
+	cookie.Name = "2fa_token"
 	cookie.Value = ""
 	cookie.Expires = time.Now().Add(-time.Hour)
-	cookie.Path = "/"
+	cookie.Path = "/login"
 	c.SetCookie(cookie)
+}
 
-	return c.Redirect(http.StatusSeeOther, "/login")
+// clearAuthCookies は認証用のクッキーを削除する
+func clearAuthCookies(c echo.Context) {
+	for _, name := range []string{"token", "refresh_token"} {
+		cookie := new(http.Cookie)
+		cookie.Name = name
+		cookie.Value = ""
+		cookie.Expires = time.Now().Add(-time.Hour)
+		cookie.Path = "/"
+		c.SetCookie(cookie)
+	}
 }
 
 // JWTミドルウェア
-func JWTMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+// Authorization: Bearer ヘッダーまたは token クッキーのトークンを検証する。
+// Bearer トークンにはJWTのほか、パーソナルアクセストークンも使える。
+// 認証に失敗した場合、ブラウザはログインページへリダイレクトし、APIクライアントには401をJSONで返す。
+func (h *AuthHandler) JWTMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		// クッキーからトークンを取得
-		cookie, err := c.Cookie("token")
-		if err != nil {
-			return c.Redirect(http.StatusSeeOther, "/login")
+		tokenString := bearerToken(c)
+
+		// パーソナルアクセストークン
+		if strings.HasPrefix(tokenString, models.TokenPrefix) {
+			accessToken, err := h.tokenStore.Authenticate(tokenString)
+			if err != nil {
+				return unauthorized(c)
+			}
+			if accessToken.Suspension != nil {
+				return suspended(c, accessToken.Suspension)
+			}
+			c.Set("user_id", accessToken.UserID)
+			c.Set("username", accessToken.Username)
+			c.Set("role", accessToken.Role)
+			c.Set("scopes", accessToken.Scopes)
+			return next(c)
+		}
+
+		fromCookie := tokenString == ""
+		if fromCookie {
+			// クッキーからトークンを取得
+			if cookie, err := c.Cookie("token"); err == nil {
+				tokenString = cookie.Value
+			}
 		}
 
 		// トークンの解析
-		token, err := jwt.Parse(cookie.Value, func(token *jwt.Token) (interface{}, error) {
+		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, errors.New("unexpected signing method")
+			}
 			return []byte(os.Getenv("JWT_SECRET")), nil
 		})
 
-		if err != nil || !token.Valid {
-			return c.Redirect(http.StatusSeeOther, "/login")
+		if err == nil && token.Valid {
+			// セッションが失効していないかチェック
+			claims := token.Claims.(jwt.MapClaims)
+			sessionID, _ := claims["sid"].(string)
+			session, err := h.sessionStore.Get(sessionID)
+			if err != nil {
+				return unauthorized(c)
+			}
+			// 利用停止は発行済みのトークンにもすぐに反映させる
+			if session.Suspension != nil {
+				return suspended(c, session.Suspension)
+			}
+			h.touchSession(c, session)
+			setSessionContext(c, session)
+			return next(c)
+		}
+
+		// ブラウザのアクセストークンが期限切れの場合はリフレッシュトークンで再発行する
+		if fromCookie {
+			if cookie, err := c.Cookie("refresh_token"); err == nil {
+				if tokens, err := h.refreshSession(cookie.Value); err == nil {
+					if tokens.session.Suspension != nil {
+						return suspended(c, tokens.session.Suspension)
+					}
+					setAuthCookies(c, tokens)
+					h.touchSession(c, tokens.session)
+					setSessionContext(c, tokens.session)
+					return next(c)
+				}
+			}
+		}
+
+		return unauthorized(c)
+	}
+}
+
+// touchSession はセッションの最終アクセス日時とIPアドレスを記録する。記録に失敗してもリクエストは続ける。
+func (h *AuthHandler) touchSession(c echo.Context, session *models.Session) {
+	if err := h.sessionStore.Touch(session.ID, c.RealIP()); err != nil {
+		log.Printf("セッションの最終アクセス日時の更新に失敗しました: %v", err)
+	}
+}
+
+// setSessionContext はログインセッションのユーザー情報をコンテキストに保存する
+func setSessionContext(c echo.Context, session *models.Session) {
+	c.Set("user_id", session.UserID)
+	c.Set("username", session.Username)
+	c.Set("role", session.Role)
+	c.Set("session_id", session.ID)
+	// ログインセッションにはすべての操作を許可する
+	c.Set("scopes", models.AllScopes)
+}
+
+// currentRole は認証済みユーザーの役割を返す
+func currentRole(c echo.Context) models.Role {
+	role, _ := c.Get("role").(models.Role)
+	return role
+}
+
+// canModify は認証済みユーザーが ownerID のユーザーのスレッドや投稿を編集・削除できるかを返す
+func canModify(c echo.Context, ownerID int) bool {
+	return models.CanModify(c.Get("user_id").(int), currentRole(c), ownerID)
+}
+
+// RequireScope は認証済みのリクエストが指定されたスコープを持っているかをチェックするミドルウェアを返す
+func RequireScope(scope models.Scope) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			scopes, _ := c.Get("scopes").([]models.Scope)
+			for _, s := range scopes {
+				if models.ScopeIncludes(s, scope) {
+					return next(c)
+				}
+			}
+
+			if isAPIRequest(c) {
+				return apiError(c, http.StatusForbidden, "この操作には"+string(scope)+"スコープが必要です。")
+			}
+			return errorPage(models.ErrForbidden, "権限エラー", "このトークンには"+string(scope)+"スコープがありません。", "/")
+		}
+	}
+}
+
+// RequirePermission は認証済みユーザーの役割が指定された権限を持っているかをチェックするミドルウェアを返す
+func RequirePermission(permission models.Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if currentRole(c).Can(permission) {
+				return next(c)
+			}
+
+			if isAPIRequest(c) {
+				return apiError(c, http.StatusForbidden, "この操作を行う権限がありません。")
+			}
+			return errorPage(models.ErrForbidden, "権限エラー", "このページを表示する権限がありません。", "/")
+		}
+	}
+}
+
+// bearerToken は Authorization ヘッダーから Bearer トークンを取り出す
+func bearerToken(c echo.Context) string {
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// isAPIRequest はブラウザ以外のクライアントからのリクエストかどうかを判定する
+func isAPIRequest(c echo.Context) bool {
+	req := c.Request()
+	if strings.HasPrefix(req.URL.Path, "/api/") || req.Header.Get(echo.HeaderAuthorization) != "" {
+		return true
+	}
+	accept := req.Header.Get(echo.HeaderAccept)
+	return strings.Contains(accept, echo.MIMEApplicationJSON) && !strings.Contains(accept, echo.MIMETextHTML)
+}
+
+// suspended は利用停止中のユーザーへの応答をクライアントの種類に応じて返す。
+// ブラウザには理由と終了日時を説明するページを表示し、APIクライアントには403を返す。
+func suspended(c echo.Context, suspension *models.Suspension) error {
+	if isAPIRequest(c) {
+		body := echo.Map{"error": suspendedMessage(suspension), "reason": suspension.Reason}
+		if suspension.Until != nil {
+			body["suspended_until"] = suspension.Until
 		}
+		return c.JSON(http.StatusForbidden, body)
+	}
+	tpl := pongo2.Must(pongo2.FromFile("templates/suspended.html"))
+	c.Response().WriteHeader(http.StatusForbidden)
+	return tpl.ExecuteWriter(pongo2.Context{
+		"suspension": suspension,
+		"message":    suspendedMessage(suspension),
+	}, c.Response().Writer)
+}
 
-		// ユーザー情報をコンテキストに保存
-		claims := token.Claims.(jwt.MapClaims)
-		c.Set("user_id", int(claims["user_id"].(float64)))
-		c.Set("username", claims["username"].(string))
+// suspendedMessage は利用停止の終了日時を含むメッセージを返す
+func suspendedMessage(suspension *models.Suspension) string {
+	if suspension.Until == nil {
+		return "このアカウントは管理者によって無期限に利用が停止されています。"
+	}
+	return "このアカウントは" + suspension.Until.Local().Format("2006年1月2日 15:04") + "まで利用が停止されています。"
+}
 
-		return next(c)
+// unauthorized は認証エラーをクライアントの種類に応じて返す
+func unauthorized(c echo.Context) error {
+	if isAPIRequest(c) {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="api"`)
+		return apiError(c, http.StatusUnauthorized, "認証が必要です。")
 	}
+	return c.Redirect(http.StatusSeeOther, "/login")
 }
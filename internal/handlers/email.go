@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"message-board/internal/mailer"
+	"message-board/internal/models"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// メールアドレス確認トークンの有効期間
+	emailVerificationLifetime = 24 * time.Hour
+	maxEmailSize              = 254
+)
+
+// EmailHandler はメールアドレスの登録と確認を提供する
+type EmailHandler struct {
+	userStore         *models.UserStore
+	verificationStore *models.EmailVerificationStore
+	mailer            mailer.Mailer
+}
+
+func NewEmailHandler(userStore *models.UserStore, verificationStore *models.EmailVerificationStore, m mailer.Mailer) *EmailHandler {
+	return &EmailHandler{userStore: userStore, verificationStore: verificationStore, mailer: m}
+}
+
+func (h *EmailHandler) ShowEmailPage(c echo.Context) error {
+	return h.renderEmail(c, "", "")
+}
+
+// UpdateEmail はメールアドレスを変更し、新しいアドレスに確認メールを送信する
+func (h *EmailHandler) UpdateEmail(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	email, ok := normalizeEmail(c.FormValue("email"))
+	if !ok {
+		return h.renderEmail(c, "メールアドレスの形式が正しくありません。", "")
+	}
+
+	if err := h.userStore.SetEmail(userID, email); err != nil {
+		if errors.Is(err, models.ErrEmailInUse) {
+			return h.renderEmail(c, "このメールアドレスは既に使用されています。", "")
+		}
+		return errorPage(err, "システムエラー", "メールアドレスの変更中にエラーが発生しました。", "/account/email")
+	}
+
+	if email == "" {
+		return h.renderEmail(c, "", "メールアドレスを削除しました。")
+	}
+	if err := sendVerificationMail(h.verificationStore, h.mailer, userID, c.Get("username").(string), email); err != nil {
+		log.Printf("確認メールの送信に失敗しました: %v", err)
+		return h.renderEmail(c, "確認メールを送信できませんでした。時間をおいて再送してください。", "")
+	}
+	return h.renderEmail(c, "", "確認メールを送信しました。メール内のリンクを開いて確認を完了してください。")
+}
+
+// ResendVerification は登録済みの未確認のメールアドレスに確認メールを再送する
+func (h *EmailHandler) ResendVerification(c echo.Context) error {
+	user, err := h.userStore.GetByID(c.Get("user_id").(int))
+	if err != nil || user.Email == "" || user.EmailVerified() {
+		return c.Redirect(http.StatusSeeOther, "/account/email")
+	}
+
+	if err := sendVerificationMail(h.verificationStore, h.mailer, user.ID, user.Username, user.Email); err != nil {
+		log.Printf("確認メールの送信に失敗しました: %v", err)
+		return h.renderEmail(c, "確認メールを送信できませんでした。時間をおいて再送してください。", "")
+	}
+	return h.renderEmail(c, "", "確認メールを再送しました。")
+}
+
+// VerifyEmail はメール内のリンクのトークンを検証し、メールアドレスを確認済みにする
+func (h *EmailHandler) VerifyEmail(c echo.Context) error {
+	if _, err := h.verificationStore.Verify(c.QueryParam("token")); err != nil {
+		if errors.Is(err, models.ErrEmailInUse) {
+			return errorPage(err, "確認できません", "このメールアドレスは他のアカウントで確認済みです。アカウントページから別のメールアドレスを登録してください。", "/account/email")
+		}
+		return errorPage(err, "リンクが無効です", "確認用のリンクが無効か、有効期限が切れています。アカウントページから確認メールを再送してください。", "/account/email")
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/account/email?verified=1")
+}
+
+func (h *EmailHandler) renderEmail(c echo.Context, errorMessage, notice string) error {
+	userID := c.Get("user_id").(int)
+
+	user, err := h.userStore.GetByID(userID)
+	if err != nil {
+		return errorPage(err, "システムエラー", "アカウント情報の取得中にエラーが発生しました。", "/")
+	}
+	if notice == "" && c.QueryParam("verified") != "" && user.EmailVerified() {
+		notice = "メールアドレスを確認しました。"
+	}
+
+	tpl := pongo2.Must(pongo2.FromFile("templates/email.html"))
+	return tpl.ExecuteWriter(pongo2.Context{
+		"email":    user.Email,
+		"verified": user.EmailVerified(),
+		"error":    errorMessage,
+		"notice":   notice,
+		"user_id":  userID,
+		"username": user.Username,
+	}, c.Response().Writer)
+}
+
+// RequireVerifiedEmail はメールアドレスを確認済みのユーザーのみを通すミドルウェアを返す
+func RequireVerifiedEmail(userStore *models.UserStore) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, err := userStore.GetByID(c.Get("user_id").(int))
+			if err == nil && user.EmailVerified() {
+				return next(c)
+			}
+
+			if isAPIRequest(c) {
+				return apiError(c, http.StatusForbidden, "投稿するにはメールアドレスの確認が必要です。")
+			}
+			return errorPage(models.ErrForbidden, "メールアドレスが未確認です", "投稿するにはメールアドレスを登録し、確認を完了してください。", "/account/email")
+		}
+	}
+}
+
+// sendVerificationMail はメールアドレス確認用のリンクを送信する
+func sendVerificationMail(store *models.EmailVerificationStore, m mailer.Mailer, userID int, username, email string) error {
+	base, err := mailBaseURL()
+	if err != nil {
+		return err
+	}
+	token, err := store.Create(userID, email, emailVerificationLifetime)
+	if err != nil {
+		return err
+	}
+
+	link := base + "/verify-email?token=" + token
+	return m.Send(mailer.Message{
+		To:      email,
+		Subject: "メールアドレスの確認",
+		Body: username + " さん\n\n" +
+			"以下のリンクを開いてメールアドレスの確認を完了してください。リンクの有効期限は24時間です。\n\n" +
+			link + "\n\n" +
+			"このメールに心当たりがない場合は破棄してください。\n",
+	})
+}
+
+// normalizeEmail は入力されたメールアドレスの前後の空白を取り除いて検証する。
+// 空の入力は「メールアドレスなし」として有効とみなす。
+func normalizeEmail(input string) (string, bool) {
+	email := strings.TrimSpace(input)
+	if email == "" {
+		return "", true
+	}
+	if len(email) > maxEmailSize {
+		return "", false
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", false
+	}
+	return email, true
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"message-board/internal/models"
+	"net/http"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/labstack/echo/v4"
+)
+
+// pageError はハンドラーが返すエラーに、エラーページに表示する内容を添えたもの
+type pageError struct {
+	err     error
+	title   string
+	message string
+	backURL string
+}
+
+func (e *pageError) Error() string {
+	if e.err == nil {
+		return e.message
+	}
+	return e.err.Error()
+}
+
+func (e *pageError) Unwrap() error { return e.err }
+
+// errorPage はエラーページを表示するエラーを返す。
+// ステータスコードは err の種類から ErrorHandler が決める（err が nil の場合は500）。
+func errorPage(err error, title, message, backURL string) error {
+	return &pageError{err: err, title: title, message: message, backURL: backURL}
+}
+
+// errorStatus はエラーの種類に対応するステータスコードを返す
+func errorStatus(err error) int {
+	var httpErr *echo.HTTPError
+	switch {
+	case errors.As(err, &httpErr):
+		return httpErr.Code
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrValidation):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// defaultErrorPage はステータスコードごとの既定のタイトルとメッセージを返す
+func defaultErrorPage(status int) (string, string) {
+	switch status {
+	case http.StatusNotFound:
+		return "ページが見つかりません", "指定されたページは存在しません。"
+	case http.StatusForbidden:
+		return "権限エラー", "この操作を行う権限がありません。"
+	case http.StatusConflict:
+		return "操作エラー", "他の操作と競合しました。もう一度やり直してください。"
+	case http.StatusUnprocessableEntity, http.StatusBadRequest:
+		return "入力エラー", "入力内容が正しくありません。"
+	case http.StatusMethodNotAllowed:
+		return "操作エラー", "この操作はサポートされていません。"
+	}
+	return "システムエラー", "処理中にエラーが発生しました。"
+}
+
+// ErrorHandler はハンドラーが返したエラーをステータスコードに変換して応答する。
+// ブラウザには error.html を表示し、APIクライアントには {"error": "..."} を返す。
+func ErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	status := errorStatus(err)
+	title, message := defaultErrorPage(status)
+	backURL := "/"
+	var page *pageError
+	if errors.As(err, &page) {
+		title, message, backURL = page.title, page.message, page.backURL
+	}
+	if status >= http.StatusInternalServerError {
+		c.Logger().Error(err)
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(status)
+	} else if isAPIRequest(c) {
+		err = c.JSON(status, echo.Map{"error": message})
+	} else {
+		err = renderErrorPage(c, status, title, message, backURL)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
+
+func renderErrorPage(c echo.Context, status int, title, message, backURL string) error {
+	tpl := pongo2.Must(pongo2.FromFile("templates/error.html"))
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	c.Response().WriteHeader(status)
+	return tpl.ExecuteWriter(pongo2.Context{
+		"error_title":   title,
+		"error_message": message,
+		"back_url":      backURL,
+	}, c.Response().Writer)
+}
@@ -1,11 +1,14 @@
 package handlers
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
+	"message-board/internal/diff"
 	"message-board/internal/models"
 
 	"github.com/flosch/pongo2/v6"
@@ -18,11 +21,14 @@ const (
 )
 
 type MessageHandler struct {
-	store *models.MessageStore
+	store     *models.MessageStore
+	postStore *models.PostStore
+	// retentionDays はゴミ箱のメッセージを完全に削除するまでの日数（ゴミ箱のページに表示する）
+	retentionDays int
 }
 
-func NewMessageHandler(store *models.MessageStore) *MessageHandler {
-	return &MessageHandler{store: store}
+func NewMessageHandler(store *models.MessageStore, postStore *models.PostStore, retentionDays int) *MessageHandler {
+	return &MessageHandler{store: store, postStore: postStore, retentionDays: retentionDays}
 }
 
 func (h *MessageHandler) ListMessages(c echo.Context) error {
@@ -33,12 +39,7 @@ func (h *MessageHandler) ListMessages(c echo.Context) error {
 
 	messages, total, err := h.store.List(page, perPage)
 	if err != nil {
-		tpl := pongo2.Must(pongo2.FromFile("templates/error.html"))
-		return tpl.ExecuteWriter(pongo2.Context{
-			"error_title":   "システムエラー",
-			"error_message": "メッセージの取得中にエラーが発生しました。",
-			"back_url":      "/",
-		}, c.Response().Writer)
+		return errorPage(err, "システムエラー", "メッセージの取得中にエラーが発生しました。", "/")
 	}
 
 	totalPages := (total + perPage - 1) / perPage
@@ -47,6 +48,7 @@ func (h *MessageHandler) ListMessages(c echo.Context) error {
 	return tpl.ExecuteWriter(pongo2.Context{
 		"messages":    messages,
 		"page":        page,
+		"page_url":    pageURL("/", nil),
 		"total_pages": totalPages,
 		"has_prev":    page > 1,
 		"has_next":    page < totalPages,
@@ -59,21 +61,47 @@ func (h *MessageHandler) GetMessage(c echo.Context) error {
 	id, _ := strconv.Atoi(c.Param("id"))
 	message, err := h.store.Get(id)
 	if err != nil {
-		tpl := pongo2.Must(pongo2.FromFile("templates/error.html"))
-		return tpl.ExecuteWriter(pongo2.Context{
-			"error_title":   "メッセージが見つかりません",
-			"error_message": "指定されたメッセージは存在しません。",
-			"back_url":      "/",
-		}, c.Response().Writer)
+		return errorPage(err, "メッセージが見つかりません", "指定されたメッセージは存在しません。", "/")
 	}
 
+	// 非表示のメッセージは投稿者本人とモデレーターのみ閲覧できる
+	if message.HiddenAt != nil && !canModify(c, message.UserID) {
+		return errorPage(models.ErrForbidden, "メッセージは非表示です", "このメッセージは通報によりモデレーターが非表示にしました。", "/")
+	}
+
+	// スレッドに対する投稿を取得
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	nodes, total, err := h.postStore.Tree(id, page, postsPerPage, maxReplyDepth)
+	if err != nil {
+		return errorPage(err, "システムエラー", "投稿の取得中にエラーが発生しました。", "/")
+	}
+
+	totalPages := (total + postsPerPage - 1) / postsPerPage
+
 	// 現在のユーザーIDを取得
 	userID := c.Get("user_id").(int)
 
 	tpl := pongo2.Must(pongo2.FromFile("templates/detail.html"))
 	return tpl.ExecuteWriter(pongo2.Context{
-		"message": message,
-		"user_id": userID,
+		"message":     message,
+		"posts":       nodes,
+		"page_url":    pageURL("/messages/"+strconv.Itoa(id), nil),
+		"post_total":  total,
+		"max_depth":   maxReplyDepth,
+		"page":        page,
+		"total_pages": totalPages,
+		"has_prev":    page > 1,
+		"has_next":    page < totalPages,
+		"user_id":     userID,
+		"username":    c.Get("username").(string),
+		// モデレーターには他のユーザーのスレッドや投稿の編集・削除ボタンも表示する
+		"can_moderate":      currentRole(c).Can(models.PermissionModerateContent),
+		"report_categories": models.AllReportCategories,
+		"reported":          c.QueryParam("reported") != "",
 	}, c.Response().Writer)
 }
 
@@ -82,34 +110,19 @@ func (h *MessageHandler) CreateMessage(c echo.Context) error {
 	content := strings.TrimSpace(c.FormValue("content"))
 
 	if len(title) == 0 || len(content) == 0 {
-		tpl := pongo2.Must(pongo2.FromFile("templates/error.html"))
-		return tpl.ExecuteWriter(pongo2.Context{
-			"error_title":   "入力エラー",
-			"error_message": "タイトルと内容は必須です。",
-			"back_url":      "/",
-		}, c.Response().Writer)
+		return errorPage(models.ErrValidation, "入力エラー", "タイトルと内容は必須です。", "/")
 	}
 
-	if len(title) > maxMsgSize || len(content) > maxMsgSize {
-		tpl := pongo2.Must(pongo2.FromFile("templates/error.html"))
-		return tpl.ExecuteWriter(pongo2.Context{
-			"error_title":   "入力エラー",
-			"error_message": "タイトルと内容は20文字以内で入力してください。",
-			"back_url":      "/",
-		}, c.Response().Writer)
+	if utf8.RuneCountInString(title) > maxMsgSize || utf8.RuneCountInString(content) > maxMsgSize {
+		return errorPage(models.ErrValidation, "入力エラー", "タイトルと内容は20文字以内で入力してください。", "/")
 	}
 
 	// 現在のユーザーIDを取得
 	userID := c.Get("user_id").(int)
 
-	err := h.store.Create(title, content, userID)
+	_, err := h.store.Create(title, content, userID)
 	if err != nil {
-		tpl := pongo2.Must(pongo2.FromFile("templates/error.html"))
-		return tpl.ExecuteWriter(pongo2.Context{
-			"error_title":   "システムエラー",
-			"error_message": "メッセージの作成中にエラーが発生しました。",
-			"back_url":      "/",
-		}, c.Response().Writer)
+		return errorPage(err, "システムエラー", "メッセージの作成中にエラーが発生しました。", "/")
 	}
 
 	return c.Redirect(http.StatusSeeOther, "/")
@@ -123,50 +136,148 @@ func (h *MessageHandler) DeleteMessage(c echo.Context) error {
 
 	err := h.store.Delete(id, userID)
 	if err != nil {
-		if err.Error() == "unauthorized: message belongs to another user" {
-			tpl := pongo2.Must(pongo2.FromFile("templates/error.html"))
-			return tpl.ExecuteWriter(pongo2.Context{
-				"error_title":   "権限エラー",
-				"error_message": "自分のメッセージのみ削除できます。",
-				"back_url":      "/",
-			}, c.Response().Writer)
+		if errors.Is(err, models.ErrForbidden) {
+			return errorPage(err, "権限エラー", "自分のメッセージのみ削除できます。", "/")
 		}
-		tpl := pongo2.Must(pongo2.FromFile("templates/error.html"))
-		return tpl.ExecuteWriter(pongo2.Context{
-			"error_title":   "システムエラー",
-			"error_message": "メッセージの削除中にエラーが発生しました。",
-			"back_url":      "/",
-		}, c.Response().Writer)
+		if errors.Is(err, models.ErrNotFound) {
+			return errorPage(err, "メッセージが見つかりません", "指定されたメッセージは存在しません。", "/")
+		}
+		return errorPage(err, "システムエラー", "メッセージの削除中にエラーが発生しました。", "/")
 	}
 	return c.Redirect(http.StatusSeeOther, "/")
 }
 
+// MessageHistory はメッセージの編集履歴と、選択した2つの版の単語単位の差分を表示する。
+// 比較する版は from と to で指定し、省略した場合は現在の版とその1つ前の版を比較する。
+func (h *MessageHandler) MessageHistory(c echo.Context) error {
+	id, _ := strconv.Atoi(c.Param("id"))
+	message, err := h.store.Get(id)
+	if err != nil {
+		return errorPage(err, "メッセージが見つかりません", "指定されたメッセージは存在しません。", "/")
+	}
+
+	// 非表示のメッセージは投稿者本人とモデレーターのみ閲覧できる
+	if message.HiddenAt != nil && !canModify(c, message.UserID) {
+		return errorPage(models.ErrForbidden, "メッセージは非表示です", "このメッセージは通報によりモデレーターが非表示にしました。", "/")
+	}
+
+	revisions, err := h.store.History(id)
+	if err != nil {
+		return errorPage(err, "システムエラー", "編集履歴の取得中にエラーが発生しました。", "/messages/"+strconv.Itoa(id))
+	}
+	if len(revisions) == 0 {
+		return errorPage(models.ErrNotFound, "メッセージが見つかりません", "指定されたメッセージは存在しません。", "/")
+	}
+
+	// 版番号は連続しているとは限らないため、番号で版を探す
+	latest := revisions[len(revisions)-1]
+	previous := latest
+	if len(revisions) > 1 {
+		previous = revisions[len(revisions)-2]
+	}
+	newer, ok := revisionParam(revisions, c.QueryParam("to"), latest.Number)
+	if !ok {
+		return errorPage(models.ErrNotFound, "版が見つかりません", "指定された版は存在しません。", "/messages/"+strconv.Itoa(id)+"/history")
+	}
+	older, ok := revisionParam(revisions, c.QueryParam("from"), previous.Number)
+	if !ok {
+		return errorPage(models.ErrNotFound, "版が見つかりません", "指定された版は存在しません。", "/messages/"+strconv.Itoa(id)+"/history")
+	}
+
+	tpl := pongo2.Must(pongo2.FromFile("templates/history.html"))
+	return tpl.ExecuteWriter(pongo2.Context{
+		"message":      message,
+		"revisions":    revisions,
+		"from":         older.Number,
+		"to":           newer.Number,
+		"title_diff":   diff.Words(older.Title, newer.Title),
+		"content_diff": diff.Words(older.Content, newer.Content),
+		// テンプレートでは名前付きの型と文字列を比較できないため、差分の種類を渡す
+		"insert":   diff.Insert,
+		"delete":   diff.Delete,
+		"user_id":  c.Get("user_id").(int),
+		"username": c.Get("username").(string),
+	}, c.Response().Writer)
+}
+
+// revisionParam は版番号のパラメータが指す版を返す。省略した場合は def の版を返す。
+// 数値でない場合や指定された番号の版がない場合は false を返す。
+func revisionParam(revisions []models.Revision, value string, def int) (models.Revision, bool) {
+	number := def
+	if value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return models.Revision{}, false
+		}
+		number = n
+	}
+	for _, r := range revisions {
+		if r.Number == number {
+			return r, true
+		}
+	}
+	return models.Revision{}, false
+}
+
+// ListTrash は現在のユーザーが削除したゴミ箱のメッセージを表示する
+func (h *MessageHandler) ListTrash(c echo.Context) error {
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	messages, total, err := h.store.Trash(c.Get("user_id").(int), page, perPage)
+	if err != nil {
+		return errorPage(err, "システムエラー", "ゴミ箱の取得中にエラーが発生しました。", "/")
+	}
+
+	totalPages := (total + perPage - 1) / perPage
+
+	tpl := pongo2.Must(pongo2.FromFile("templates/trash.html"))
+	return tpl.ExecuteWriter(pongo2.Context{
+		"messages":       messages,
+		"retention_days": h.retentionDays,
+		"restored":       c.QueryParam("restored") != "",
+		"page":           page,
+		"page_url":       pageURL("/trash", nil),
+		"total_pages":    totalPages,
+		"has_prev":       page > 1,
+		"has_next":       page < totalPages,
+		"user_id":        c.Get("user_id").(int),
+		"username":       c.Get("username").(string),
+	}, c.Response().Writer)
+}
+
+// RestoreMessage はゴミ箱のメッセージを元に戻す
+func (h *MessageHandler) RestoreMessage(c echo.Context) error {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	err := h.store.Restore(id, c.Get("user_id").(int))
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return errorPage(err, "メッセージが見つかりません", "指定されたメッセージはゴミ箱にありません。", "/trash")
+		}
+		return errorPage(err, "システムエラー", "メッセージの復元中にエラーが発生しました。", "/trash")
+	}
+	return c.Redirect(http.StatusSeeOther, "/trash?restored=1")
+}
+
 func (h *MessageHandler) EditMessage(c echo.Context) error {
 	id, _ := strconv.Atoi(c.Param("id"))
 	message, err := h.store.Get(id)
 	if err != nil {
-		tpl := pongo2.Must(pongo2.FromFile("templates/error.html"))
-		return tpl.ExecuteWriter(pongo2.Context{
-			"error_title":   "メッセージが見つかりません",
-			"error_message": "指定されたメッセージは存在しません。",
-			"back_url":      "/",
-		}, c.Response().Writer)
+		return errorPage(err, "メッセージが見つかりません", "指定されたメッセージは存在しません。", "/")
 	}
 
-	// 現在のユーザーIDを取得し、権限をチェック
-	userID := c.Get("user_id").(int)
-	if message.UserID != userID {
-		tpl := pongo2.Must(pongo2.FromFile("templates/error.html"))
-		return tpl.ExecuteWriter(pongo2.Context{
-			"error_title":   "権限エラー",
-			"error_message": "自分のメッセージのみ編集できます。",
-			"back_url":      "/messages/" + strconv.Itoa(id),
-		}, c.Response().Writer)
+	// 現在のユーザーの権限をチェック
+	if !canModify(c, message.UserID) {
+		return errorPage(models.ErrForbidden, "権限エラー", "自分のメッセージのみ編集できます。", "/messages/"+strconv.Itoa(id))
 	}
 
 	tpl := pongo2.Must(pongo2.FromFile("templates/edit.html"))
 	return tpl.ExecuteWriter(pongo2.Context{
-		"message": message,
+		"message":    message,
+		"moderating": message.UserID != c.Get("user_id").(int),
 	}, c.Response().Writer)
 }
 
@@ -175,64 +286,114 @@ func (h *MessageHandler) UpdateMessage(c echo.Context) error {
 	title := strings.TrimSpace(c.FormValue("title"))
 	content := strings.TrimSpace(c.FormValue("content"))
 
-	fmt.Println(title, content, id)
-
 	if len(title) == 0 || len(content) == 0 {
-		tpl := pongo2.Must(pongo2.FromFile("templates/error.html"))
-		return tpl.ExecuteWriter(pongo2.Context{
-			"error_title":   "入力エラー",
-			"error_message": "タイトルと内容は必須です。",
-			"back_url":      "/messages/" + strconv.Itoa(id) + "/edit",
-		}, c.Response().Writer)
+		return errorPage(models.ErrValidation, "入力エラー", "タイトルと内容は必須です。", "/messages/"+strconv.Itoa(id)+"/edit")
 	}
 
-	if len(title) > maxMsgSize || len(content) > maxMsgSize {
-		tpl := pongo2.Must(pongo2.FromFile("templates/error.html"))
-		return tpl.ExecuteWriter(pongo2.Context{
-			"error_title":   "入力エラー",
-			"error_message": "タイトルと内容は20文字以内で入力してください。",
-			"back_url":      "/messages/" + strconv.Itoa(id) + "/edit",
-		}, c.Response().Writer)
+	if utf8.RuneCountInString(title) > maxMsgSize || utf8.RuneCountInString(content) > maxMsgSize {
+		return errorPage(models.ErrValidation, "入力エラー", "タイトルと内容は20文字以内で入力してください。", "/messages/"+strconv.Itoa(id)+"/edit")
+	}
+
+	// 編集を始めたときの版番号
+	version, err := strconv.Atoi(c.FormValue("version"))
+	if err != nil {
+		return errorPage(models.ErrValidation, "入力エラー", "編集画面から保存してください。", "/messages/"+strconv.Itoa(id)+"/edit")
 	}
 
 	// 現在のユーザーIDを取得
 	userID := c.Get("user_id").(int)
 
-	err := h.store.Update(id, title, content, userID)
+	err = h.store.Update(id, title, content, userID, version)
 	if err != nil {
-		if err.Error() == "unauthorized: message belongs to another user" {
-			tpl := pongo2.Must(pongo2.FromFile("templates/error.html"))
-			return tpl.ExecuteWriter(pongo2.Context{
-				"error_title":   "権限エラー",
-				"error_message": "自分のメッセージのみ編集できます。",
-				"back_url":      "/messages/" + strconv.Itoa(id),
-			}, c.Response().Writer)
+		if errors.Is(err, models.ErrConflict) {
+			return h.renderConflict(c, id, title, content)
+		}
+		if errors.Is(err, models.ErrForbidden) {
+			return errorPage(err, "権限エラー", "自分のメッセージのみ編集できます。", "/messages/"+strconv.Itoa(id))
+		}
+		if errors.Is(err, models.ErrNotFound) {
+			return errorPage(err, "メッセージが見つかりません", "指定されたメッセージは存在しません。", "/")
 		}
-		tpl := pongo2.Must(pongo2.FromFile("templates/error.html"))
-		return tpl.ExecuteWriter(pongo2.Context{
-			"error_title":   "システムエラー",
-			"error_message": "メッセージの更新中にエラーが発生しました。",
-			"back_url":      "/messages/" + strconv.Itoa(id),
-		}, c.Response().Writer)
+		return errorPage(err, "システムエラー", "メッセージの更新中にエラーが発生しました。", "/messages/"+strconv.Itoa(id))
 	}
 
 	return c.Redirect(http.StatusSeeOther, "/messages/"+strconv.Itoa(id))
 }
 
+// renderConflict は編集中に他の更新があった場合に、最新の版と自分の編集内容を並べて表示する。
+// フォームには最新の版番号を設定するため、内容を確認してから保存し直せる。
+func (h *MessageHandler) renderConflict(c echo.Context, id int, title, content string) error {
+	current, err := h.store.Get(id)
+	if err != nil {
+		return errorPage(err, "メッセージが見つかりません", "編集中のメッセージは削除されました。", "/")
+	}
+
+	c.Response().WriteHeader(http.StatusConflict)
+	tpl := pongo2.Must(pongo2.FromFile("templates/conflict.html"))
+	return tpl.ExecuteWriter(pongo2.Context{
+		"message":      current,
+		"title":        title,
+		"content":      content,
+		"title_diff":   diff.Words(current.Title, title),
+		"content_diff": diff.Words(current.Content, content),
+		"insert":       diff.Insert,
+		"delete":       diff.Delete,
+		"user_id":      c.Get("user_id").(int),
+		"username":     c.Get("username").(string),
+	}, c.Response().Writer)
+}
+
+// 検索フォームの絞り込み項目。検索欄のインライン演算子と同じ名前を使う。
+var searchFilterFields = []string{"author", "from", "to", "edited", "sort"}
+
+var searchSortLabels = map[models.SearchSort]string{
+	models.SearchSortRelevance: "関連度順",
+	models.SearchSortNewest:    "新しい順",
+	models.SearchSortOldest:    "古い順",
+	models.SearchSortUpdated:   "更新日時順",
+}
+
 func (h *MessageHandler) SearchMessages(c echo.Context) error {
 	query := strings.TrimSpace(c.QueryParam("q"))
-	if query == "" {
+	opts, params, err := parseSearchOptions(c)
+	if err != nil {
+		return errorPage(models.ErrValidation, "入力エラー", "検索条件が正しくありません。日付はYYYY-MM-DD形式で入力してください。", "/")
+	}
+
+	if opts.IsEmpty() {
 		return c.Redirect(http.StatusSeeOther, "/")
 	}
 
-	messages, err := h.store.Search(query)
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	messages, total, err := h.store.Search(opts, page, perPage)
 	if err != nil {
-		tpl := pongo2.Must(pongo2.FromFile("templates/error.html"))
-		return tpl.ExecuteWriter(pongo2.Context{
-			"error_title":   "システムエラー",
-			"error_message": "メッセージの検索中にエラーが発生しました。",
-			"back_url":      "/",
-		}, c.Response().Writer)
+		return errorPage(err, "システムエラー", "メッセージの検索中にエラーが発生しました。", "/")
+	}
+
+	totalPages := (total + perPage - 1) / perPage
+	searchURL := pageURL("/search", params)
+
+	// 範囲外のページは最終ページへ
+	if totalPages > 0 && page > totalPages {
+		return c.Redirect(http.StatusSeeOther, searchURL+"page="+strconv.Itoa(totalPages))
+	}
+
+	// 適用中の絞り込み条件を結果ページに表示する
+	filters := pongo2.Context{
+		"author":     opts.Author,
+		"edited":     opts.EditedOnly,
+		"sort":       string(opts.Sort),
+		"sort_label": searchSortLabels[opts.Sort],
+	}
+	if !opts.From.IsZero() {
+		filters["from"] = opts.From.Format(models.SearchDateLayout)
+	}
+	if !opts.To.IsZero() {
+		filters["to"] = opts.To.Format(models.SearchDateLayout)
 	}
 
 	// 現在のユーザーIDを取得
@@ -240,8 +401,50 @@ func (h *MessageHandler) SearchMessages(c echo.Context) error {
 
 	tpl := pongo2.Must(pongo2.FromFile("templates/index.html"))
 	return tpl.ExecuteWriter(pongo2.Context{
-		"messages": messages,
-		"query":    query,
-		"user_id":  userID,
+		"messages":    messages,
+		"searching":   true,
+		"query":       query,
+		"search_text": opts.Query,
+		"search_mode": string(opts.Mode),
+		"filters":     filters,
+		"total":       total,
+		"page":        page,
+		"page_url":    searchURL,
+		"total_pages": totalPages,
+		"has_prev":    page > 1,
+		"has_next":    page < totalPages,
+		"user_id":     userID,
+		"username":    c.Get("username").(string),
 	}, c.Response().Writer)
 }
+
+// parseSearchOptions は検索欄のインライン演算子を解釈し、フォームの絞り込み項目で上書きする。
+// 検索結果のページ間で引き継ぐためのクエリパラメータも返す。
+func parseSearchOptions(c echo.Context) (models.SearchOptions, url.Values, error) {
+	query := strings.TrimSpace(c.QueryParam("q"))
+	opts, err := models.ParseSearchQuery(query)
+	params := url.Values{"q": {query}}
+	for _, field := range searchFilterFields {
+		value := strings.TrimSpace(c.QueryParam(field))
+		if value == "" {
+			continue
+		}
+		params.Set(field, value)
+		if _, setErr := opts.Set(field, value); setErr != nil {
+			err = setErr
+		}
+	}
+
+	opts.Mode = models.ParseSearchMode(c.QueryParam("mode"))
+	params.Set("mode", string(opts.Mode))
+	return opts, params, err
+}
+
+// pageURL はページ番号を付け足せる形のURLを返す（例: "/search?q=foo&"）。
+// 検索条件などのクエリパラメータをページ間で引き継ぐために使う。
+func pageURL(path string, params url.Values) string {
+	if len(params) == 0 {
+		return path + "?"
+	}
+	return path + "?" + params.Encode() + "&"
+}
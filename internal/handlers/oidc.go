@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"message-board/internal/models"
+	"message-board/internal/oidc"
+	"message-board/internal/validation"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+)
+
+// OIDCプロバイダーでのログインを終えて戻ってくるまでの猶予
+const oidcLoginLifetime = 10 * time.Minute
+
+// OIDCHandler は外部のIDプロバイダー（OpenID Connect）によるログインを提供する
+type OIDCHandler struct {
+	provider      *oidc.Provider
+	userStore     *models.UserStore
+	identityStore *models.IdentityStore
+	auth          *AuthHandler
+}
+
+func NewOIDCHandler(provider *oidc.Provider, userStore *models.UserStore, identityStore *models.IdentityStore, auth *AuthHandler) *OIDCHandler {
+	return &OIDCHandler{provider: provider, userStore: userStore, identityStore: identityStore, auth: auth}
+}
+
+// Start は state・nonce・PKCE の値をクッキーに保存し、プロバイダーの認可エンドポイントへリダイレクトする
+func (h *OIDCHandler) Start(c echo.Context) error {
+	state, err := oidc.RandomString(16)
+	if err != nil {
+		return ssoError(c, "ログイン処理中にエラーが発生しました。")
+	}
+	nonce, err := oidc.RandomString(16)
+	if err != nil {
+		return ssoError(c, "ログイン処理中にエラーが発生しました。")
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		return ssoError(c, "ログイン処理中にエラーが発生しました。")
+	}
+
+	authURL, err := h.provider.AuthCodeURL(c.Request().Context(), state, nonce, challenge)
+	if err != nil {
+		log.Printf("OIDCプロバイダーの設定を取得できません: %v", err)
+		return ssoError(c, "シングルサインオンのサービスに接続できません。時間をおいてもう一度お試しください。")
+	}
+	if err := setOIDCCookie(c, state, nonce, verifier); err != nil {
+		return ssoError(c, "ログイン処理中にエラーが発生しました。")
+	}
+
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback はプロバイダーから戻ってきた認可コードを検証し、対応するユーザーでログインさせる。
+// 初めてのユーザーは自動的に作成する。
+func (h *OIDCHandler) Callback(c echo.Context) error {
+	state, nonce, verifier, err := oidcCookie(c)
+	clearOIDCCookie(c)
+	if err != nil || c.QueryParam("state") != state {
+		return ssoError(c, "ログインの有効期限が切れたか、リクエストが正しくありません。もう一度お試しください。")
+	}
+	if c.QueryParam("error") != "" {
+		return ssoError(c, "シングルサインオンでのログインがキャンセルされました。")
+	}
+
+	claims, err := h.provider.Exchange(c.Request().Context(), c.QueryParam("code"), verifier, nonce)
+	if err != nil {
+		log.Printf("OIDCの認可コードを検証できません: %v", err)
+		return ssoError(c, "シングルサインオンでのログインに失敗しました。")
+	}
+
+	userID, err := h.identityStore.FindUserID(claims.Issuer, claims.Subject)
+	if errors.Is(err, models.ErrNotFound) {
+		userID, err = h.provision(claims)
+	}
+	if err != nil {
+		log.Printf("OIDCのユーザーを取得できません: %v", err)
+		return ssoError(c, "ログイン処理中にエラーが発生しました。")
+	}
+
+	user, err := h.userStore.GetByID(userID)
+	if err != nil {
+		return ssoError(c, "ログイン処理中にエラーが発生しました。")
+	}
+
+	return h.auth.loginUser(c, user)
+}
+
+// provision は外部アカウントに対応するユーザーを作成する。
+// ユーザー名はプロバイダーのユーザー名かメールアドレスから作り、重複する場合は番号を付ける。
+func (h *OIDCHandler) provision(claims *oidc.Claims) (int, error) {
+	base := ssoUsername(claims)
+	for i := 1; i <= 100; i++ {
+		username := base
+		if i > 1 {
+			suffix := strconv.Itoa(i)
+			if len(base)+len(suffix) > validation.MaxUsernameLength {
+				username = base[:validation.MaxUsernameLength-len(suffix)]
+			}
+			username += suffix
+		}
+
+		email := ""
+		if claims.EmailVerified {
+			email = claims.Email
+		}
+		userID, err := h.identityStore.Provision(claims.Issuer, claims.Subject, username, email, claims.EmailVerified)
+		if errors.Is(err, models.ErrUsernameTaken) {
+			continue
+		}
+		return userID, err
+	}
+	return 0, errors.New("could not find an available username")
+}
+
+// ssoUsername はクレームからユーザー名の規則に合う候補を作る
+func ssoUsername(claims *oidc.Claims) string {
+	candidates := []string{claims.PreferredUsername}
+	if local, _, ok := strings.Cut(claims.Email, "@"); ok {
+		candidates = append(candidates, local)
+	}
+
+	for _, candidate := range candidates {
+		var b strings.Builder
+		for _, r := range candidate {
+			if validation.UsernameRune(r, b.Len() == 0) {
+				b.WriteRune(r)
+			}
+		}
+		username := b.String()
+		if len(username) > validation.MaxUsernameLength {
+			username = username[:validation.MaxUsernameLength]
+		}
+		if validation.Username(username) == "" {
+			return username
+		}
+	}
+	return "user"
+}
+
+// ssoName はログイン画面に表示するシングルサインオンの名前を返す。設定されていない場合は空文字列。
+func ssoName() string {
+	if os.Getenv("OIDC_ISSUER_URL") == "" {
+		return ""
+	}
+	if name := os.Getenv("OIDC_PROVIDER_NAME"); name != "" {
+		return name
+	}
+	return "SSO"
+}
+
+func ssoError(c echo.Context, message string) error {
+	return errorPage(echo.ErrUnauthorized, "ログインエラー", message, "/login")
+}
+
+// setOIDCCookie は認可リクエストの state・nonce・code_verifier を署名付きのクッキーに保存する
+func setOIDCCookie(c echo.Context, state, nonce, verifier string) error {
+	expiresAt := time.Now().Add(oidcLoginLifetime)
+
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["purpose"] = "oidc"
+	claims["state"] = state
+	claims["nonce"] = nonce
+	claims["verifier"] = verifier
+	claims["exp"] = expiresAt.Unix()
+
+	t, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		return err
+	}
+
+	cookie := new(http.Cookie)
+	cookie.Name = "oidc_state"
+	cookie.Value = t
+	cookie.Expires = expiresAt
+	cookie.Path = "/login/oidc"
+	cookie.HttpOnly = true
+	// プロバイダーからのリダイレクト（トップレベルのGET）でも送信されるよう Lax にする
+	cookie.SameSite = http.SameSiteLaxMode
+	c.SetCookie(cookie)
+	return nil
+}
+
+func oidcCookie(c echo.Context) (state, nonce, verifier string, err error) {
+	cookie, err := c.Cookie("oidc_state")
+	if err != nil {
+		return "", "", "", err
+	}
+
+	token, err := jwt.Parse(cookie.Value, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return []byte(os.Getenv("JWT_SECRET")), nil
+	})
+	if err != nil || !token.Valid {
+		return "", "", "", errors.New("invalid oidc state")
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	if claims["purpose"] != "oidc" {
+		return "", "", "", errors.New("invalid oidc state")
+	}
+	state, _ = claims["state"].(string)
+	nonce, _ = claims["nonce"].(string)
+	verifier, _ = claims["verifier"].(string)
+	if state == "" || nonce == "" || verifier == "" {
+		return "", "", "", errors.New("invalid oidc state")
+	}
+	return state, nonce, verifier, nil
+}
+
+func clearOIDCCookie(c echo.Context) {
+	cookie := new(http.Cookie)
+	cookie.Name = "oidc_state"
+	cookie.Value = ""
+	cookie.Expires = time.Now().Add(-time.Hour)
+	cookie.Path = "/login/oidc"
+	c.SetCookie(cookie)
+}
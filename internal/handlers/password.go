@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"message-board/internal/mailer"
+	"message-board/internal/models"
+	"message-board/internal/validation"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/labstack/echo/v4"
+)
+
+// パスワード再設定トークンの有効期間
+const passwordResetLifetime = time.Hour
+
+// PasswordHandler はパスワードの変更と再設定を提供する
+type PasswordHandler struct {
+	userStore    *models.UserStore
+	sessionStore *models.SessionStore
+	resetStore   *models.PasswordResetStore
+	mailer       mailer.Mailer
+	policy       *validation.PasswordPolicy
+}
+
+func NewPasswordHandler(userStore *models.UserStore, sessionStore *models.SessionStore, resetStore *models.PasswordResetStore, m mailer.Mailer, policy *validation.PasswordPolicy) *PasswordHandler {
+	return &PasswordHandler{userStore: userStore, sessionStore: sessionStore, resetStore: resetStore, mailer: m, policy: policy}
+}
+
+func (h *PasswordHandler) ShowChangePage(c echo.Context) error {
+	user, err := h.userStore.GetByID(c.Get("user_id").(int))
+	if err != nil {
+		return errorPage(err, "システムエラー", "アカウント情報の取得中にエラーが発生しました。", "/")
+	}
+	return h.renderChange(c, user, "", false)
+}
+
+// ChangePassword は現在のパスワードを確認してパスワードを変更し、他の端末をログアウトさせる。
+// シングルサインオンで作成されたパスワードのないアカウントは、現在のパスワードなしで最初のパスワードを設定する。
+func (h *PasswordHandler) ChangePassword(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	current := c.FormValue("current_password")
+	password := c.FormValue("password")
+
+	user, err := h.userStore.GetByID(userID)
+	if err != nil {
+		return errorPage(err, "システムエラー", "アカウント情報の取得中にエラーが発生しました。", "/")
+	}
+
+	if msg := h.policy.Check(password, user.Username); msg != "" {
+		return h.renderChange(c, user, msg, false)
+	}
+	if password != c.FormValue("password_confirm") {
+		return h.renderChange(c, user, "新しいパスワードが確認用と一致しません。", false)
+	}
+
+	if user.HasPassword() {
+		err = h.userStore.ChangePassword(userID, current, password)
+	} else {
+		err = h.userStore.SetInitialPassword(userID, password)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidPassword):
+			return h.renderChange(c, user, "現在のパスワードが正しくありません。", false)
+		case errors.Is(err, models.ErrConflict):
+			// 別の端末で先にパスワードが設定された
+			return errorPage(err, "パスワードは設定済みです", "パスワードはすでに設定されています。現在のパスワードを入力して変更してください。", "/account/password")
+		}
+		return errorPage(err, "システムエラー", "パスワードの変更中にエラーが発生しました。", "/account/password")
+	}
+
+	// 現在の端末以外のセッションを失効させる
+	sessionID, _ := c.Get("session_id").(string)
+	if err := h.sessionStore.RevokeOthers(userID, sessionID); err != nil {
+		log.Printf("セッションの失効に失敗しました: %v", err)
+	}
+
+	return h.renderChange(c, user, "", true)
+}
+
+func (h *PasswordHandler) renderChange(c echo.Context, user *models.User, errorMessage string, changed bool) error {
+	tpl := pongo2.Must(pongo2.FromFile("templates/password_change.html"))
+	return tpl.ExecuteWriter(pongo2.Context{
+		"error":               errorMessage,
+		"changed":             changed,
+		"has_password":        changed || user.HasPassword(),
+		"password_min_length": h.policy.MinLength,
+		"user_id":             user.ID,
+		"username":            user.Username,
+	}, c.Response().Writer)
+}
+
+func (h *PasswordHandler) ShowForgotPage(c echo.Context) error {
+	tpl := pongo2.Must(pongo2.FromFile("templates/password_forgot.html"))
+	return tpl.ExecuteWriter(pongo2.Context{}, c.Response().Writer)
+}
+
+// RequestReset はパスワード再設定用のリンクをメールで送信する。
+// ユーザーが存在するかどうかは応答から分からないようにする。
+func (h *PasswordHandler) RequestReset(c echo.Context) error {
+	username := strings.TrimSpace(c.FormValue("username"))
+
+	// 確認済みのメールアドレスがある場合のみ送信する
+	if user, err := h.userStore.GetByUsername(username); err == nil && user.EmailVerified() {
+		if err := h.sendResetMail(user); err != nil {
+			log.Printf("パスワード再設定メールの送信に失敗しました: %v", err)
+		}
+	}
+
+	tpl := pongo2.Must(pongo2.FromFile("templates/password_forgot.html"))
+	return tpl.ExecuteWriter(pongo2.Context{"sent": true}, c.Response().Writer)
+}
+
+func (h *PasswordHandler) sendResetMail(user *models.User) error {
+	base, err := mailBaseURL()
+	if err != nil {
+		return err
+	}
+	token, err := h.resetStore.Create(user.ID, passwordResetLifetime)
+	if err != nil {
+		return err
+	}
+
+	link := base + "/password/reset?token=" + token
+	return h.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "パスワードの再設定",
+		Body: user.Username + " さん\n\n" +
+			"以下のリンクからパスワードを再設定してください。リンクの有効期限は1時間です。\n\n" +
+			link + "\n\n" +
+			"このメールに心当たりがない場合は破棄してください。\n",
+	})
+}
+
+func (h *PasswordHandler) ShowResetPage(c echo.Context) error {
+	token := c.QueryParam("token")
+	if _, err := h.resetStore.Validate(token); err != nil {
+		return invalidResetToken(c)
+	}
+	return h.renderReset(c, token, "")
+}
+
+// ResetPassword はトークンを消費して新しいパスワードを設定し、すべての端末をログアウトさせる
+func (h *PasswordHandler) ResetPassword(c echo.Context) error {
+	token := c.FormValue("token")
+	password := c.FormValue("password")
+
+	userID, err := h.resetStore.Validate(token)
+	if err != nil {
+		return invalidResetToken(c)
+	}
+	user, err := h.userStore.GetByID(userID)
+	if err != nil {
+		return invalidResetToken(c)
+	}
+
+	if msg := h.policy.Check(password, user.Username); msg != "" {
+		return h.renderReset(c, token, msg)
+	}
+	if password != c.FormValue("password_confirm") {
+		return h.renderReset(c, token, "新しいパスワードが確認用と一致しません。")
+	}
+
+	// 検証後に同じトークンが使われていないか、消費時にも確認する。
+	// トークンの消費とパスワードの変更は同じトランザクションで行い、失敗してもリンクを使えるようにする。
+	if _, err := h.resetStore.Reset(token, password); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return invalidResetToken(c)
+		}
+		return errorPage(err, "システムエラー", "パスワードの再設定中にエラーが発生しました。", "/password/forgot")
+	}
+	if err := h.sessionStore.RevokeAll(userID); err != nil {
+		log.Printf("セッションの失効に失敗しました: %v", err)
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/login?reset=done")
+}
+
+func (h *PasswordHandler) renderReset(c echo.Context, token, errorMessage string) error {
+	tpl := pongo2.Must(pongo2.FromFile("templates/password_reset.html"))
+	return tpl.ExecuteWriter(pongo2.Context{
+		"token":               token,
+		"error":               errorMessage,
+		"password_min_length": h.policy.MinLength,
+	}, c.Response().Writer)
+}
+
+func invalidResetToken(c echo.Context) error {
+	return errorPage(models.ErrNotFound, "リンクが無効です", "パスワード再設定のリンクが無効か、有効期限が切れています。もう一度やり直してください。", "/password/forgot")
+}
+
+// errNoBaseURL は BASE_URL が未設定のためメール内のリンクを作れないことを表す
+var errNoBaseURL = errors.New("BASE_URL is not set")
+
+// mailBaseURL はメールに記載するリンクの起点となるURLを返す。
+// リクエストの Host ヘッダーは偽装できるため使わず、BASE_URL が未設定の場合はエラーを返す。
+func mailBaseURL() (string, error) {
+	url := os.Getenv("BASE_URL")
+	if url == "" {
+		return "", errNoBaseURL
+	}
+	return strings.TrimSuffix(url, "/"), nil
+}
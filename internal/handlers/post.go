@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"message-board/internal/models"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	postsPerPage = 10
+	maxPostSize  = 200
+	// この深さを超える返信は同じ深さに平坦化して表示する
+	maxReplyDepth = 4
+)
+
+type PostHandler struct {
+	store        *models.PostStore
+	messageStore *models.MessageStore
+}
+
+func NewPostHandler(store *models.PostStore, messageStore *models.MessageStore) *PostHandler {
+	return &PostHandler{store: store, messageStore: messageStore}
+}
+
+func (h *PostHandler) CreatePost(c echo.Context) error {
+	messageID, _ := strconv.Atoi(c.Param("id"))
+	parentID, _ := strconv.Atoi(c.FormValue("parent_post_id"))
+	content := strings.TrimSpace(c.FormValue("content"))
+	backURL := "/messages/" + strconv.Itoa(messageID)
+
+	message, err := h.messageStore.Get(messageID)
+	if err != nil {
+		return errorPage(err, "メッセージが見つかりません", "指定されたメッセージは存在しません。", "/")
+	}
+	// 非表示のメッセージには投稿者本人とモデレーター以外は投稿できない
+	if message.HiddenAt != nil && !canModify(c, message.UserID) {
+		return errorPage(models.ErrForbidden, "メッセージは非表示です", "このメッセージは通報によりモデレーターが非表示にしました。", "/")
+	}
+
+	if len(content) == 0 {
+		return errorPage(models.ErrValidation, "入力エラー", "投稿内容は必須です。", backURL)
+	}
+
+	if utf8.RuneCountInString(content) > maxPostSize {
+		return errorPage(models.ErrValidation, "入力エラー", "投稿内容は200文字以内で入力してください。", backURL)
+	}
+
+	// 現在のユーザーIDを取得
+	userID := c.Get("user_id").(int)
+
+	postID, err := h.store.Create(messageID, parentID, content, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return errorPage(err, "投稿が見つかりません", "返信先の投稿は存在しません。", backURL)
+		}
+		return errorPage(err, "システムエラー", "投稿の作成中にエラーが発生しました。", backURL)
+	}
+
+	anchor := "#post-" + strconv.Itoa(postID)
+
+	// 返信は返信元と同じページに表示される
+	if parentID != 0 {
+		page, _ := strconv.Atoi(c.FormValue("page"))
+		if page > 1 {
+			return c.Redirect(http.StatusSeeOther, backURL+"?page="+strconv.Itoa(page)+anchor)
+		}
+		return c.Redirect(http.StatusSeeOther, backURL+anchor)
+	}
+
+	// 新しいトップレベル投稿が表示される最終ページへ移動
+	total, err := h.store.RootCount(messageID)
+	if err != nil || total <= postsPerPage {
+		return c.Redirect(http.StatusSeeOther, backURL+anchor)
+	}
+	lastPage := (total + postsPerPage - 1) / postsPerPage
+	return c.Redirect(http.StatusSeeOther, backURL+"?page="+strconv.Itoa(lastPage)+anchor)
+}
+
+func (h *PostHandler) EditPost(c echo.Context) error {
+	messageID, _ := strconv.Atoi(c.Param("id"))
+	postID, _ := strconv.Atoi(c.Param("post_id"))
+	backURL := "/messages/" + strconv.Itoa(messageID)
+
+	post, err := h.store.Get(postID)
+	if err != nil || post.MessageID != messageID || post.DeletedAt != nil {
+		return errorPage(models.ErrNotFound, "投稿が見つかりません", "指定された投稿は存在しません。", backURL)
+	}
+
+	// 現在のユーザーの権限をチェック
+	userID := c.Get("user_id").(int)
+	if !canModify(c, post.UserID) {
+		return errorPage(models.ErrForbidden, "権限エラー", "自分の投稿のみ編集できます。", backURL)
+	}
+
+	tpl := pongo2.Must(pongo2.FromFile("templates/post_edit.html"))
+	return tpl.ExecuteWriter(pongo2.Context{
+		"post":       post,
+		"moderating": post.UserID != userID,
+		"user_id":    userID,
+		"username":   c.Get("username").(string),
+	}, c.Response().Writer)
+}
+
+func (h *PostHandler) UpdatePost(c echo.Context) error {
+	messageID, _ := strconv.Atoi(c.Param("id"))
+	postID, _ := strconv.Atoi(c.Param("post_id"))
+	content := strings.TrimSpace(c.FormValue("content"))
+	backURL := "/messages/" + strconv.Itoa(messageID)
+	editURL := backURL + "/posts/" + strconv.Itoa(postID) + "/edit"
+
+	if len(content) == 0 {
+		return errorPage(models.ErrValidation, "入力エラー", "投稿内容は必須です。", editURL)
+	}
+
+	if utf8.RuneCountInString(content) > maxPostSize {
+		return errorPage(models.ErrValidation, "入力エラー", "投稿内容は200文字以内で入力してください。", editURL)
+	}
+
+	post, err := h.store.Get(postID)
+	if err != nil || post.MessageID != messageID || post.DeletedAt != nil {
+		return errorPage(models.ErrNotFound, "投稿が見つかりません", "指定された投稿は存在しません。", backURL)
+	}
+
+	// 現在のユーザーIDを取得
+	userID := c.Get("user_id").(int)
+
+	err = h.store.Update(postID, content, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrForbidden) {
+			return errorPage(err, "権限エラー", "自分の投稿のみ編集できます。", backURL)
+		}
+		return errorPage(err, "システムエラー", "投稿の更新中にエラーが発生しました。", backURL)
+	}
+
+	return c.Redirect(http.StatusSeeOther, backURL)
+}
+
+func (h *PostHandler) DeletePost(c echo.Context) error {
+	messageID, _ := strconv.Atoi(c.Param("id"))
+	postID, _ := strconv.Atoi(c.Param("post_id"))
+	backURL := "/messages/" + strconv.Itoa(messageID)
+
+	post, err := h.store.Get(postID)
+	if err != nil || post.MessageID != messageID || post.DeletedAt != nil {
+		return errorPage(models.ErrNotFound, "投稿が見つかりません", "指定された投稿は存在しません。", backURL)
+	}
+
+	// 現在のユーザーIDを取得
+	userID := c.Get("user_id").(int)
+
+	err = h.store.Delete(postID, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrForbidden) {
+			return errorPage(err, "権限エラー", "自分の投稿のみ削除できます。", backURL)
+		}
+		return errorPage(err, "システムエラー", "投稿の削除中にエラーが発生しました。", backURL)
+	}
+	return c.Redirect(http.StatusSeeOther, backURL)
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"message-board/internal/models"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	reportGroupsPerPage = 10
+	maxReportNoteSize   = 500
+)
+
+// モデレーションキューの操作後に表示するメッセージ
+var reportNotices = map[string]string{
+	"dismiss": "通報を却下しました。",
+	"hide":    "メッセージを非表示にしました。",
+	"delete":  "メッセージを削除しました。",
+	"suspend": "投稿者の利用を停止し、メッセージを非表示にしました。",
+}
+
+// ReportHandler はメッセージの通報と、モデレーター向けの通報の対応ページを提供する
+type ReportHandler struct {
+	reportStore  *models.ReportStore
+	messageStore *models.MessageStore
+	userStore    *models.UserStore
+}
+
+func NewReportHandler(reportStore *models.ReportStore, messageStore *models.MessageStore, userStore *models.UserStore) *ReportHandler {
+	return &ReportHandler{reportStore: reportStore, messageStore: messageStore, userStore: userStore}
+}
+
+// Report はメッセージを通報する
+func (h *ReportHandler) Report(c echo.Context) error {
+	id, _ := strconv.Atoi(c.Param("id"))
+	backURL := "/messages/" + strconv.Itoa(id)
+
+	category, ok := models.ParseReportCategory(c.FormValue("category"))
+	note := strings.TrimSpace(c.FormValue("note"))
+	if !ok || utf8.RuneCountInString(note) > maxReportNoteSize {
+		return errorPage(models.ErrValidation, "入力エラー", "通報の理由を選択し、補足は500文字以内で入力してください。", backURL)
+	}
+
+	err := h.reportStore.Create(id, c.Get("user_id").(int), category, note)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrConflict):
+			return errorPage(err, "通報済みです", "このメッセージはすでに通報されています。モデレーターの対応をお待ちください。", backURL)
+		case errors.Is(err, models.ErrNotFound):
+			return errorPage(err, "メッセージが見つかりません", "指定されたメッセージは存在しません。", "/")
+		}
+		return errorPage(err, "システムエラー", "通報の送信中にエラーが発生しました。", backURL)
+	}
+	return c.Redirect(http.StatusSeeOther, backURL+"?reported=1")
+}
+
+// Queue は未対応の通報をメッセージごとにまとめて表示する
+func (h *ReportHandler) Queue(c echo.Context) error {
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	groups, total, err := h.reportStore.Queue(page, reportGroupsPerPage)
+	if err != nil {
+		return errorPage(err, "システムエラー", "通報の取得中にエラーが発生しました。", "/")
+	}
+
+	totalPages := (total + reportGroupsPerPage - 1) / reportGroupsPerPage
+
+	tpl := pongo2.Must(pongo2.FromFile("templates/moderation_reports.html"))
+	return tpl.ExecuteWriter(pongo2.Context{
+		"groups":      groups,
+		"days":        suspensionDays,
+		"total":       total,
+		"page":        page,
+		"page_url":    pageURL("/moderation/reports", nil),
+		"total_pages": totalPages,
+		"has_prev":    page > 1,
+		"has_next":    page < totalPages,
+		"notice":      reportNotices[c.QueryParam("done")],
+		"user_id":     c.Get("user_id").(int),
+		"username":    c.Get("username").(string),
+	}, c.Response().Writer)
+}
+
+// Resolve はメッセージに対する通報に対応する。
+// 投稿者の利用停止を選んだ場合は、指定した日数と理由で投稿者の利用を停止し、メッセージを非表示にする。
+func (h *ReportHandler) Resolve(c echo.Context) error {
+	messageID, _ := strconv.Atoi(c.Param("id"))
+	actorID := c.Get("user_id").(int)
+
+	resolution, ok := models.ParseReportResolution(c.FormValue("action"))
+	if !ok {
+		return reportError(models.ErrValidation, "入力エラー", "対応の指定が正しくありません。")
+	}
+
+	var until *time.Time
+	var reason string
+	if resolution == models.ResolutionSuspend {
+		reason = strings.TrimSpace(c.FormValue("reason"))
+		if reason == "" || utf8.RuneCountInString(reason) > maxSuspensionReasonSize {
+			return reportError(models.ErrValidation, "入力エラー", "利用停止の理由を200文字以内で入力してください。")
+		}
+
+		message, err := h.messageStore.Get(messageID)
+		if err != nil {
+			return reportActionError(err, "メッセージが見つかりません", "指定されたメッセージは存在しません。")
+		}
+		if message.UserID == 0 || message.UserID == actorID {
+			return reportError(models.ErrForbidden, "操作エラー", "このメッセージの投稿者の利用は停止できません。")
+		}
+		author, err := h.userStore.GetByID(message.UserID)
+		if err != nil {
+			return reportActionError(err, "ユーザーが見つかりません", "指定されたユーザーは存在しません。")
+		}
+		// モデレーター同士や管理者の利用停止は管理画面で行う
+		if author.Role.Can(models.PermissionModerateContent) {
+			return reportError(models.ErrForbidden, "操作エラー", "モデレーターや管理者の利用は停止できません。")
+		}
+
+		if days, err := strconv.Atoi(c.FormValue("days")); err == nil && days > 0 {
+			t := time.Now().AddDate(0, 0, days)
+			until = &t
+		}
+	}
+
+	// 利用停止は通報の対応と同じトランザクションで行い、対応に失敗した場合は利用停止もしない
+	if err := h.reportStore.Resolve(actorID, messageID, resolution, until, reason); err != nil {
+		return reportActionError(err, "通報が見つかりません", "このメッセージへの未対応の通報はありません。")
+	}
+	return c.Redirect(http.StatusSeeOther, "/moderation/reports?done="+string(resolution))
+}
+
+// Unhide は非表示にしたメッセージを再表示する
+func (h *ReportHandler) Unhide(c echo.Context) error {
+	messageID, _ := strconv.Atoi(c.Param("id"))
+	if err := h.messageStore.Unhide(messageID, c.Get("user_id").(int)); err != nil {
+		return reportActionError(err, "メッセージが見つかりません", "指定されたメッセージは存在しません。")
+	}
+	return c.Redirect(http.StatusSeeOther, "/messages/"+strconv.Itoa(messageID))
+}
+
+func reportError(err error, title, message string) error {
+	return errorPage(err, title, message, "/moderation/reports")
+}
+
+// reportActionError は対象が見つからない場合はその旨を、それ以外はシステムエラーを表示する
+func reportActionError(err error, title, notFound string) error {
+	if errors.Is(err, models.ErrNotFound) {
+		return reportError(err, title, notFound)
+	}
+	return reportError(err, "システムエラー", "通報の対応中にエラーが発生しました。")
+}
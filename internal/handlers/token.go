@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"message-board/internal/models"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/labstack/echo/v4"
+)
+
+const maxTokenNameSize = 100
+
+// TokenHandler はパーソナルアクセストークンの設定ページを提供する
+type TokenHandler struct {
+	store *models.TokenStore
+}
+
+func NewTokenHandler(store *models.TokenStore) *TokenHandler {
+	return &TokenHandler{store: store}
+}
+
+func (h *TokenHandler) ListTokens(c echo.Context) error {
+	return h.renderTokens(c, "")
+}
+
+func (h *TokenHandler) CreateToken(c echo.Context) error {
+	name := strings.TrimSpace(c.FormValue("name"))
+	days, _ := strconv.Atoi(c.FormValue("expires_in_days"))
+
+	if len(name) == 0 || utf8.RuneCountInString(name) > maxTokenNameSize {
+		return errorPage(models.ErrValidation, "入力エラー", "トークン名は100文字以内で入力してください。", "/settings/tokens")
+	}
+
+	form, _ := c.FormParams()
+	var scopes []models.Scope
+	for _, value := range form["scopes"] {
+		if scope, ok := models.ParseScope(value); ok {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return errorPage(models.ErrValidation, "入力エラー", "スコープを1つ以上選択してください。", "/settings/tokens")
+	}
+
+	// 0日の場合は無期限
+	var expiresAt *time.Time
+	if days > 0 {
+		t := time.Now().AddDate(0, 0, days)
+		expiresAt = &t
+	}
+
+	userID := c.Get("user_id").(int)
+
+	token, err := h.store.Create(userID, name, scopes, expiresAt)
+	if err != nil {
+		return errorPage(err, "システムエラー", "トークンの発行中にエラーが発生しました。", "/settings/tokens")
+	}
+
+	// 平文のトークンはこの画面でのみ表示する
+	return h.renderTokens(c, token)
+}
+
+func (h *TokenHandler) RevokeToken(c echo.Context) error {
+	id, _ := strconv.Atoi(c.Param("id"))
+	userID := c.Get("user_id").(int)
+
+	if err := h.store.Revoke(id, userID); err != nil {
+		return errorPage(err, "トークンが見つかりません", "指定されたトークンは存在しないか、すでに失効しています。", "/settings/tokens")
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/settings/tokens")
+}
+
+func (h *TokenHandler) renderTokens(c echo.Context, newToken string) error {
+	userID := c.Get("user_id").(int)
+
+	tokens, err := h.store.List(userID)
+	if err != nil {
+		return errorPage(err, "システムエラー", "トークンの取得中にエラーが発生しました。", "/")
+	}
+
+	tpl := pongo2.Must(pongo2.FromFile("templates/tokens.html"))
+	return tpl.ExecuteWriter(pongo2.Context{
+		"tokens":    tokens,
+		"new_token": newToken,
+		"scopes":    models.AllScopes,
+		"user_id":   userID,
+		"username":  c.Get("username").(string),
+	}, c.Response().Writer)
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"message-board/internal/models"
+	"message-board/internal/totp"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/labstack/echo/v4"
+	"github.com/skip2/go-qrcode"
+)
+
+// 認証アプリに表示されるサービス名
+const totpIssuer = "スレッドボード"
+
+// TwoFactorHandler は2段階認証の設定ページを提供する
+type TwoFactorHandler struct {
+	userStore      *models.UserStore
+	twoFactorStore *models.TwoFactorStore
+}
+
+func NewTwoFactorHandler(userStore *models.UserStore, twoFactorStore *models.TwoFactorStore) *TwoFactorHandler {
+	return &TwoFactorHandler{userStore: userStore, twoFactorStore: twoFactorStore}
+}
+
+// ShowTwoFactorPage は2段階認証の状態を表示する
+func (h *TwoFactorHandler) ShowTwoFactorPage(c echo.Context) error {
+	return h.renderStatus(c, "")
+}
+
+// BeginSetup は新しいシークレットを発行し、認証アプリに登録するためのQRコードを表示する
+func (h *TwoFactorHandler) BeginSetup(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	secret, err := h.twoFactorStore.Begin(userID)
+	if err != nil {
+		return c.Redirect(http.StatusSeeOther, "/account/2fa")
+	}
+	return h.renderSetup(c, secret, "")
+}
+
+// Enable は認証アプリに表示されたコードを確認して2段階認証を有効にし、リカバリーコードを表示する
+func (h *TwoFactorHandler) Enable(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	codes, err := h.twoFactorStore.Enable(userID, c.FormValue("code"))
+	if err != nil {
+		secret, pendingErr := h.twoFactorStore.PendingSecret(userID)
+		if pendingErr != nil {
+			return c.Redirect(http.StatusSeeOther, "/account/2fa")
+		}
+		return h.renderSetup(c, secret, "確認コードが正しくありません。認証アプリに表示されている最新のコードを入力してください。")
+	}
+
+	// リカバリーコードはこの画面でのみ表示する
+	tpl := pongo2.Must(pongo2.FromFile("templates/two_factor.html"))
+	return tpl.ExecuteWriter(pongo2.Context{
+		"enabled":        true,
+		"recovery_codes": codes,
+		"user_id":        userID,
+		"username":       c.Get("username").(string),
+	}, c.Response().Writer)
+}
+
+// Disable は現在のパスワードを確認して2段階認証を無効にする
+func (h *TwoFactorHandler) Disable(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	if err := h.userStore.VerifyPassword(userID, c.FormValue("password")); err != nil {
+		return h.renderStatus(c, "パスワードが正しくありません。")
+	}
+
+	if err := h.twoFactorStore.Disable(userID); err != nil {
+		return errorPage(err, "システムエラー", "2段階認証の無効化中にエラーが発生しました。", "/account/2fa")
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/account/2fa")
+}
+
+func (h *TwoFactorHandler) renderStatus(c echo.Context, errorMessage string) error {
+	userID := c.Get("user_id").(int)
+
+	user, err := h.userStore.GetByID(userID)
+	if err != nil {
+		return errorPage(err, "システムエラー", "アカウント情報の取得中にエラーが発生しました。", "/")
+	}
+
+	remaining := 0
+	if user.TwoFactorEnabled {
+		remaining, _ = h.twoFactorStore.RemainingRecoveryCodes(userID)
+	}
+
+	tpl := pongo2.Must(pongo2.FromFile("templates/two_factor.html"))
+	return tpl.ExecuteWriter(pongo2.Context{
+		"enabled":            user.TwoFactorEnabled,
+		"has_password":       user.HasPassword(),
+		"remaining_recovery": remaining,
+		"error":              errorMessage,
+		"user_id":            userID,
+		"username":           user.Username,
+	}, c.Response().Writer)
+}
+
+// renderSetup はシークレットとそのQRコードを表示する。
+// シークレットを外部のスクリプトに渡さないよう、QRコードはサーバーで画像にする。
+func (h *TwoFactorHandler) renderSetup(c echo.Context, secret, errorMessage string) error {
+	username := c.Get("username").(string)
+
+	png, err := qrcode.Encode(totp.ProvisioningURI(totpIssuer, username, secret), qrcode.Medium, 192)
+	if err != nil {
+		return errorPage(err, "システムエラー", "QRコードの作成中にエラーが発生しました。", "/account/2fa")
+	}
+
+	tpl := pongo2.Must(pongo2.FromFile("templates/two_factor_setup.html"))
+	return tpl.ExecuteWriter(pongo2.Context{
+		"secret":   secret,
+		"qr_code":  "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+		"error":    errorMessage,
+		"user_id":  c.Get("user_id").(int),
+		"username": username,
+	}, c.Response().Writer)
+}
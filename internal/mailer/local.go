@@ -0,0 +1,80 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LogMailer はメールを送信せずに io.Writer に書き出す。ローカル開発用。
+type LogMailer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	from string
+}
+
+func NewLogMailer(w io.Writer, from string) *LogMailer {
+	return &LogMailer{w: w, from: from}
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.w, "----- mail -----\n%s\n----------------\n", format(m.from, msg, time.Now()))
+	return err
+}
+
+// FileMailer はメールを1通ずつ .eml ファイルとしてディレクトリに保存する。ローカル開発用。
+type FileMailer struct {
+	mu   sync.Mutex
+	dir  string
+	from string
+	seq  int
+}
+
+func NewFileMailer(dir, from string) *FileMailer {
+	return &FileMailer{dir: dir, from: from}
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+	now := time.Now()
+	m.seq++
+	name := fmt.Sprintf("%s-%04d.eml", now.Format("20060102-150405"), m.seq)
+	return os.WriteFile(filepath.Join(m.dir, name), format(m.from, msg, now), 0o644)
+}
+
+// CaptureMailer は送信されたメールをメモリに保持する。テスト用。
+type CaptureMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewCaptureMailer() *CaptureMailer {
+	return &CaptureMailer{}
+}
+
+func (m *CaptureMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages はこれまでに送信されたメールを送信順に返す
+func (m *CaptureMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
@@ -0,0 +1,60 @@
+// Package mailer はメール送信の抽象化と実装を提供する。
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Message は送信するメール
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer はメールを送信する
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// FromEnv は環境変数の設定に応じた Mailer を返す。
+// SMTP_HOST が設定されていればSMTPで送信し、MAIL_DIR が設定されていればファイルに書き出す。
+// どちらも設定されていない場合は標準出力にログとして出力する。
+func FromEnv() Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "noreply@localhost"
+	}
+
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+		if err != nil {
+			port = 587
+		}
+		return NewSMTPMailer(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from)
+	}
+	if dir := os.Getenv("MAIL_DIR"); dir != "" {
+		return NewFileMailer(dir, from)
+	}
+	return NewLogMailer(os.Stdout, from)
+}
+
+// format はメッセージをRFC 5322形式のバイト列に変換する
+func format(from string, msg Message, date time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(msg.Body)
+	return buf.Bytes()
+}
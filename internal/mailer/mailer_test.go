@@ -0,0 +1,69 @@
+package mailer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogMailer_Send(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewLogMailer(&buf, "noreply@example.com")
+
+	err := m.Send(Message{To: "alice@example.com", Subject: "パスワードの再設定", Body: "本文です"})
+	if err != nil {
+		t.Fatalf("メールの送信に失敗しました: %v", err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{"From: noreply@example.com", "To: alice@example.com", "Subject: =?UTF-8?b?", "本文です"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("出力に'%s'が含まれていません: %s", want, out)
+		}
+	}
+}
+
+func TestFileMailer_Send(t *testing.T) {
+	dir := t.TempDir()
+	m := NewFileMailer(filepath.Join(dir, "mail"), "noreply@example.com")
+
+	for i := 0; i < 2; i++ {
+		if err := m.Send(Message{To: "alice@example.com", Subject: "件名", Body: "本文"}); err != nil {
+			t.Fatalf("メールの送信に失敗しました: %v", err)
+		}
+	}
+
+	files, err := os.ReadDir(filepath.Join(dir, "mail"))
+	if err != nil {
+		t.Fatalf("出力ディレクトリの読み込みに失敗しました: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("ファイル数が2であるべきですが、実際は%dです", len(files))
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "mail", files[0].Name()))
+	if err != nil {
+		t.Fatalf("ファイルの読み込みに失敗しました: %v", err)
+	}
+	if !strings.HasSuffix(string(data), "\r\n\r\n本文") {
+		t.Errorf("本文が正しく書き出されていません: %q", data)
+	}
+}
+
+func TestCaptureMailer_Send(t *testing.T) {
+	m := NewCaptureMailer()
+
+	if err := m.Send(Message{To: "alice@example.com", Subject: "確認", Body: "本文"}); err != nil {
+		t.Fatalf("メールの送信に失敗しました: %v", err)
+	}
+
+	messages := m.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("メール数が1であるべきですが、実際は%dです", len(messages))
+	}
+	if messages[0].To != "alice@example.com" {
+		t.Errorf("宛先が'alice@example.com'であるべきですが、実際は'%s'です", messages[0].To)
+	}
+}
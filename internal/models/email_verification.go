@@ -0,0 +1,76 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// EmailVerificationStore はメールアドレス確認用のトークンを管理する。
+// トークンは発行時のメールアドレスに紐づき、アドレスが変更された後は使えない。
+type EmailVerificationStore struct {
+	db *sql.DB
+}
+
+func NewEmailVerificationStore(db *sql.DB) *EmailVerificationStore {
+	return &EmailVerificationStore{db: db}
+}
+
+// Create はメールアドレス確認用のトークンを発行し、平文のトークンを返す
+func (s *EmailVerificationStore) Create(userID int, email string, lifetime time.Duration) (string, error) {
+	token, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO email_verifications (user_id, email, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)`, userID, email, hashToken(token), time.Now().Add(lifetime))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Verify はトークンを使用済みにし、ユーザーのメールアドレスを確認済みにする。
+// 確認したユーザーのIDを返す。
+func (s *EmailVerificationStore) Verify(token string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var email string
+	err = tx.QueryRow(`
+		UPDATE email_verifications SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING user_id, email`, hashToken(token)).Scan(&userID, &email)
+	if err == sql.ErrNoRows {
+		return 0, notFoundError("verification token not found")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// トークン発行後にメールアドレスが変更されている場合は確認しない
+	result, err := tx.Exec(`
+		UPDATE users SET email_verified_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND email = $2`, userID, email)
+	// 同じアドレスを登録した他のユーザーが先に確認を済ませた
+	if isUniqueViolation(err, "users_email_idx") {
+		return 0, ErrEmailInUse
+	}
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rows == 0 {
+		return 0, notFoundError("verification token not found")
+	}
+
+	return userID, tx.Commit()
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEmailVerificationStore_Verify(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	userStore := NewUserStore(db)
+	store := NewEmailVerificationStore(db)
+
+	userID, err := userStore.CreateWithEmail("testuser", "password123", "test@example.com")
+	if err != nil {
+		t.Fatalf("テストユーザーの作成に失敗しました: %v", err)
+	}
+
+	user, err := userStore.GetByID(userID)
+	if err != nil {
+		t.Fatalf("ユーザー取得に失敗しました: %v", err)
+	}
+	if user.EmailVerified() {
+		t.Error("登録直後のメールアドレスが確認済みになっています")
+	}
+
+	token, err := store.Create(userID, "test@example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("確認トークンの発行に失敗しました: %v", err)
+	}
+	if _, err := store.Verify(token); err != nil {
+		t.Fatalf("メールアドレスの確認に失敗しました: %v", err)
+	}
+
+	user, err = userStore.GetByID(userID)
+	if err != nil {
+		t.Fatalf("ユーザー取得に失敗しました: %v", err)
+	}
+	if !user.EmailVerified() {
+		t.Error("メールアドレスが確認済みになっていません")
+	}
+
+	// 使用済みのトークンは再利用できない
+	if _, err := store.Verify(token); err == nil {
+		t.Error("使用済みのトークンを再利用できてしまいました")
+	}
+
+	// メールアドレスを変更すると未確認に戻り、古いアドレスのトークンは使えない
+	oldToken, err := store.Create(userID, "test@example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("確認トークンの発行に失敗しました: %v", err)
+	}
+	if err := userStore.SetEmail(userID, "new@example.com"); err != nil {
+		t.Fatalf("メールアドレスの変更に失敗しました: %v", err)
+	}
+	if _, err := store.Verify(oldToken); err == nil {
+		t.Error("変更前のアドレスのトークンで確認できてしまいました")
+	}
+	user, err = userStore.GetByID(userID)
+	if err != nil {
+		t.Fatalf("ユーザー取得に失敗しました: %v", err)
+	}
+	if user.EmailVerified() {
+		t.Error("変更後のメールアドレスが確認済みになっています")
+	}
+}
+
+func TestUserStore_EmailUnique(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewUserStore(db)
+	verificationStore := NewEmailVerificationStore(db)
+
+	aliceID, err := store.CreateWithEmail("alice", "password123", "alice@example.com")
+	if err != nil {
+		t.Fatalf("テストユーザーの作成に失敗しました: %v", err)
+	}
+
+	// 未確認のアドレスは他のユーザーも登録できる
+	malloryID, err := store.CreateWithEmail("mallory", "password123", "Alice@Example.com")
+	if err != nil {
+		t.Fatalf("未確認のアドレスで登録できません: %v", err)
+	}
+
+	token, err := verificationStore.Create(aliceID, "alice@example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("確認トークンの発行に失敗しました: %v", err)
+	}
+	malloryToken, err := verificationStore.Create(malloryID, "Alice@Example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("確認トークンの発行に失敗しました: %v", err)
+	}
+	if _, err := verificationStore.Verify(token); err != nil {
+		t.Fatalf("メールアドレスの確認に失敗しました: %v", err)
+	}
+
+	// 確認済みのアドレスは、大文字小文字だけが異なる場合も他のユーザーが確認・登録できない
+	_, err = verificationStore.Verify(malloryToken)
+	if err == nil || err.Error() != "email already in use" {
+		t.Errorf("'email already in use'エラーを期待しますが、実際は'%v'です", err)
+	}
+	_, err = store.CreateWithEmail("bob", "password123", "ALICE@example.com")
+	if err == nil || err.Error() != "email already in use" {
+		t.Errorf("'email already in use'エラーを期待しますが、実際は'%v'です", err)
+	}
+	if err := store.SetEmail(malloryID, "alice@example.com"); err == nil || err.Error() != "email already in use" {
+		t.Errorf("'email already in use'エラーを期待しますが、実際は'%v'です", err)
+	}
+	// 本人は確認済みのアドレスを登録し直せる
+	if err := store.SetEmail(aliceID, "alice@example.com"); err != nil {
+		t.Errorf("自分のアドレスを登録し直せません: %v", err)
+	}
+
+	// メールアドレスなしのユーザーは複数作成できる
+	if err := store.Create("carol", "password123"); err != nil {
+		t.Errorf("メールアドレスなしのユーザー作成に失敗しました: %v", err)
+	}
+	if err := store.Create("dave", "password123"); err != nil {
+		t.Errorf("メールアドレスなしのユーザー作成に失敗しました: %v", err)
+	}
+}
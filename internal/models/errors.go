@@ -0,0 +1,36 @@
+package models
+
+import "errors"
+
+// エラーの種類。ハンドラーは errors.Is で種類を判定してステータスコードを決める。
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrForbidden  = errors.New("forbidden")
+	ErrConflict   = errors.New("conflict")
+	ErrValidation = errors.New("validation failed")
+)
+
+// 呼び出し側で個別に扱うエラー
+var (
+	ErrUsernameTaken   = conflictError("username already taken")
+	ErrEmailInUse      = conflictError("email already in use")
+	ErrInvalidPassword = errors.New("invalid password")
+)
+
+// kindError は従来のエラーメッセージを保ったまま、errors.Is で種類を判定できるようにする
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e *kindError) Error() string { return e.msg }
+
+func (e *kindError) Unwrap() error { return e.kind }
+
+func notFoundError(msg string) error { return &kindError{kind: ErrNotFound, msg: msg} }
+
+func forbiddenError(msg string) error { return &kindError{kind: ErrForbidden, msg: msg} }
+
+func conflictError(msg string) error { return &kindError{kind: ErrConflict, msg: msg} }
+
+func validationError(msg string) error { return &kindError{kind: ErrValidation, msg: msg} }
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestKindError(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind error
+		msg  string
+	}{
+		{notFoundError("message not found"), ErrNotFound, "message not found"},
+		{forbiddenError("unauthorized: message belongs to another user"), ErrForbidden, "unauthorized: message belongs to another user"},
+		{conflictError("version conflict"), ErrConflict, "version conflict"},
+		{validationError("invalid role"), ErrValidation, "invalid role"},
+		{ErrEmailInUse, ErrConflict, "email already in use"},
+		{&SuspendedError{}, ErrForbidden, "account suspended"},
+	}
+
+	for _, tt := range tests {
+		if tt.err.Error() != tt.msg {
+			t.Errorf("エラーメッセージが'%s'であるべきですが、実際は'%s'です", tt.msg, tt.err)
+		}
+		// ラップされていても種類を判定できる
+		wrapped := fmt.Errorf("wrapped: %w", tt.err)
+		if !errors.Is(wrapped, tt.kind) {
+			t.Errorf("'%s'が%vとして判定されません", tt.err, tt.kind)
+		}
+	}
+
+	if errors.Is(ErrUsernameTaken, ErrEmailInUse) {
+		t.Error("異なる競合エラーが同じエラーとして判定されました")
+	}
+	if errors.Is(notFoundError("post not found"), ErrForbidden) {
+		t.Error("見つからないエラーが権限エラーとして判定されました")
+	}
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"database/sql"
+)
+
+// IdentityStore は外部のIDプロバイダー（OIDC）のアカウントとユーザーの対応を管理する
+type IdentityStore struct {
+	db *sql.DB
+}
+
+func NewIdentityStore(db *sql.DB) *IdentityStore {
+	return &IdentityStore{db: db}
+}
+
+// FindUserID は外部アカウントに対応するユーザーのIDを返し、最終ログイン日時を更新する
+func (s *IdentityStore) FindUserID(issuer, subject string) (int, error) {
+	var userID int
+	err := s.db.QueryRow(`
+		UPDATE identities SET last_login_at = CURRENT_TIMESTAMP
+		WHERE issuer = $1 AND subject = $2
+		RETURNING user_id`, issuer, subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, notFoundError("identity not found")
+	}
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// Provision は外部アカウントで初めてログインしたユーザーを作成し、外部アカウントと紐づける。
+// パスワードは設定しないため、パスワードでのログインはできない。
+// email が他のユーザーに使われている場合は、メールアドレスなしで作成する。
+func (s *IdentityStore) Provision(issuer, subject, username, email string, emailVerified bool) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// メールアドレスが他のユーザーに使われている場合は、セーブポイントまで戻してメールアドレスなしで作り直す
+	if _, err := tx.Exec(`SAVEPOINT provision_user`); err != nil {
+		return 0, err
+	}
+	userID, err := insertProvisionedUser(tx, username, email, emailVerified)
+	if email != "" && isUniqueViolation(err, "users_email_idx") {
+		if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT provision_user`); err != nil {
+			return 0, err
+		}
+		userID, err = insertProvisionedUser(tx, username, "", false)
+	}
+	if isUniqueViolation(err, "users_username_key") {
+		return 0, ErrUsernameTaken
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// identities には外部アカウントのメールアドレスをそのまま記録する
+	_, err = tx.Exec(`
+		INSERT INTO identities (user_id, issuer, subject, email, last_login_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)`, userID, issuer, subject, email)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
+
+// insertProvisionedUser はパスワードのないユーザーを作成する。email が空の場合はメールアドレスなしで作成する。
+func insertProvisionedUser(tx *sql.Tx, username, email string, emailVerified bool) (int, error) {
+	var userID int
+	err := tx.QueryRow(`
+		INSERT INTO users (username, password_hash, email, email_verified_at)
+		VALUES ($1, '', NULLIF($2::text, ''), CASE WHEN $3::boolean AND $2::text <> '' THEN CURRENT_TIMESTAMP END)
+		RETURNING id`, username, email, emailVerified).Scan(&userID)
+	return userID, err
+}
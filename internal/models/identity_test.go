@@ -0,0 +1,101 @@
+package models
+
+import "testing"
+
+func TestIdentityStore_Provision(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	userStore := NewUserStore(db)
+	store := NewIdentityStore(db)
+
+	if _, err := store.FindUserID("https://idp.example.com", "alice-sub"); err == nil {
+		t.Error("未登録の外部アカウントが見つかってしまいました")
+	}
+
+	userID, err := store.Provision("https://idp.example.com", "alice-sub", "alice", "alice@example.com", true)
+	if err != nil {
+		t.Fatalf("ユーザーの自動作成に失敗しました: %v", err)
+	}
+
+	found, err := store.FindUserID("https://idp.example.com", "alice-sub")
+	if err != nil {
+		t.Fatalf("外部アカウントの検索に失敗しました: %v", err)
+	}
+	if found != userID {
+		t.Errorf("ユーザーIDが%dであるべきですが、実際は%dです", userID, found)
+	}
+
+	user, err := userStore.GetByID(userID)
+	if err != nil {
+		t.Fatalf("ユーザー取得に失敗しました: %v", err)
+	}
+	if !user.EmailVerified() {
+		t.Error("プロバイダーで確認済みのメールアドレスが確認済みになっていません")
+	}
+	// パスワードではログインできない
+	if _, err := userStore.Authenticate("alice", ""); err == nil {
+		t.Error("パスワードなしでログインできてしまいました")
+	}
+
+	// 同じユーザー名は使えない
+	_, err = store.Provision("https://idp.example.com", "other-sub", "alice", "", false)
+	if err == nil || err.Error() != "username already taken" {
+		t.Errorf("'username already taken'エラーを期待しますが、実際は'%v'です", err)
+	}
+
+	// 使用済みのメールアドレスは登録しない
+	bobID, err := store.Provision("https://idp.example.com", "bob-sub", "bob", "ALICE@example.com", true)
+	if err != nil {
+		t.Fatalf("ユーザーの自動作成に失敗しました: %v", err)
+	}
+	bob, err := userStore.GetByID(bobID)
+	if err != nil {
+		t.Fatalf("ユーザー取得に失敗しました: %v", err)
+	}
+	if bob.Email != "" {
+		t.Errorf("使用済みのメールアドレスが登録されています: %s", bob.Email)
+	}
+}
+
+// シングルサインオンで作成したアカウントはパスワードを持たず、最初のパスワードを設定できる
+func TestIdentityStore_ProvisionedUserSetsPassword(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	userStore := NewUserStore(db)
+	store := NewIdentityStore(db)
+
+	userID, err := store.Provision("https://idp.example.com", "alice-sub", "alice", "", false)
+	if err != nil {
+		t.Fatalf("ユーザーの自動作成に失敗しました: %v", err)
+	}
+	user, err := userStore.GetByID(userID)
+	if err != nil {
+		t.Fatalf("ユーザー取得に失敗しました: %v", err)
+	}
+	if user.HasPassword() {
+		t.Error("自動作成したユーザーにパスワードが設定されています")
+	}
+	if err := userStore.VerifyPassword(userID, ""); err == nil {
+		t.Error("空のパスワードで確認できてしまいました")
+	}
+
+	if err := userStore.SetInitialPassword(userID, "password123"); err != nil {
+		t.Fatalf("最初のパスワードの設定に失敗しました: %v", err)
+	}
+	if err := userStore.VerifyPassword(userID, "password123"); err != nil {
+		t.Errorf("設定したパスワードで確認できません: %v", err)
+	}
+	if _, err := userStore.Authenticate("alice", "password123"); err != nil {
+		t.Errorf("設定したパスワードでログインできません: %v", err)
+	}
+
+	// パスワードを設定した後は、現在のパスワードなしでは変更できない
+	if err := userStore.SetInitialPassword(userID, "otherpassword"); err == nil || err.Error() != "password already set" {
+		t.Errorf("'password already set'エラーを期待しますが、実際は'%v'です", err)
+	}
+	if err := userStore.ChangePassword(userID, "password123", "newpassword456"); err != nil {
+		t.Errorf("パスワードの変更に失敗しました: %v", err)
+	}
+}
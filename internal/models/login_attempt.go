@@ -0,0 +1,218 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+	"unicode/utf8"
+)
+
+// ログイン失敗の理由（login_attempts.reason に記録する）
+const (
+	LoginFailureUnknownUser     = "unknown_user"
+	LoginFailureInvalidPassword = "invalid_password"
+	LoginFailureInvalidCode     = "invalid_code"
+	LoginFailureSuspended       = "suspended"
+	// LoginFailureThrottled は待ち時間中の試行。記録はするが失敗回数には数えない。
+	LoginFailureThrottled = "throttled"
+)
+
+// 記録するユーザー名の最大文字数。存在しない長いユーザー名で表を肥大化させないため。
+const maxAttemptUsernameSize = 100
+
+// LoginPolicy はログイン失敗時の待ち時間とロックアウトの設定
+type LoginPolicy struct {
+	// FreeAttempts 回までの失敗は待ち時間なしで再試行できる
+	FreeAttempts int
+	// BaseDelay は FreeAttempts を超えた最初の失敗後の待ち時間。以降は失敗ごとに2倍になる。
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutThreshold 回失敗すると、最後の失敗から LockoutDuration の間ログインできない。
+	// 失敗回数は LockoutDuration の期間内のものを数える。
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+var (
+	// UserLoginPolicy はユーザー名ごとの設定
+	UserLoginPolicy = LoginPolicy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         5 * time.Minute,
+		LockoutThreshold: 10,
+		LockoutDuration:  15 * time.Minute,
+	}
+	// IPLoginPolicy はIPアドレスごとの設定。複数のユーザーが同じIPを共有する場合を考えて緩くする。
+	IPLoginPolicy = LoginPolicy{
+		FreeAttempts:     20,
+		BaseDelay:        time.Second,
+		MaxDelay:         5 * time.Minute,
+		LockoutThreshold: 100,
+		LockoutDuration:  15 * time.Minute,
+	}
+)
+
+// Wait は直近の失敗回数と最後の失敗時刻から、次に試行できるまでの待ち時間を返す
+func (p LoginPolicy) Wait(failures int, lastFailure, now time.Time) time.Duration {
+	var until time.Time
+	switch {
+	case failures >= p.LockoutThreshold:
+		until = lastFailure.Add(p.LockoutDuration)
+	case failures > p.FreeAttempts:
+		delay := p.BaseDelay << (failures - p.FreeAttempts - 1)
+		if delay <= 0 || delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+		until = lastFailure.Add(delay)
+	default:
+		return 0
+	}
+
+	if wait := until.Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// LoginAttemptStore はログインの試行を記録し、総当たり攻撃を抑止する
+type LoginAttemptStore struct {
+	db *sql.DB
+}
+
+func NewLoginAttemptStore(db *sql.DB) *LoginAttemptStore {
+	return &LoginAttemptStore{db: db}
+}
+
+// 結果が決まる前のログインの試行に記録する理由。結果が決まるまでは失敗として数える。
+const loginAttemptPending = "pending"
+
+// 同じユーザー名の試行の開始を待つ最大時間
+const loginAttemptLockTimeout = "5s"
+
+// LoginAttempt は開始したログインの試行。
+// 試行は開始時に失敗として記録しておき、結果が決まったら Fail で理由を記録するか、Close で取り消す。
+// 並行した試行もそれぞれ失敗として数えられるため、失敗回数による待ち時間やロックアウトを回避できない。
+type LoginAttempt struct {
+	db *sql.DB
+	id int64
+	// done は結果を記録済み（または待ち時間中で試行しなかった）かどうか
+	done bool
+}
+
+// Begin はログインの試行を開始し、ユーザー名とリクエスト元IPの直近の失敗から、次に試行できるまでの待ち時間を返す。
+// 待ち時間中の場合は試行を記録して終了する。待ち時間の確認と試行の記録の間だけ同じユーザー名の試行を待たせ、
+// パスワードの検証中はロックもデータベースの接続も保持しない。
+func (s *LoginAttemptStore) Begin(username, ipAddress, userAgent string) (*LoginAttempt, time.Duration, error) {
+	username = truncateAttemptUsername(username)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	// ロックはトランザクションの終了で解放される
+	if _, err := tx.Exec(`SET LOCAL lock_timeout = '` + loginAttemptLockTimeout + `'`); err != nil {
+		return nil, 0, err
+	}
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, username); err != nil {
+		return nil, 0, err
+	}
+
+	wait, err := loginWait(tx, username, ipAddress)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	reason := loginAttemptPending
+	if wait > 0 {
+		reason = LoginFailureThrottled
+	}
+	attempt := &LoginAttempt{db: s.db, done: wait > 0}
+	err = tx.QueryRow(`
+		INSERT INTO login_attempts (username, ip_address, user_agent, succeeded, reason)
+		VALUES ($1, $2, $3, FALSE, $4)
+		RETURNING id`,
+		username, ipAddress, userAgent, reason).Scan(&attempt.id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, 0, err
+	}
+	return attempt, wait, nil
+}
+
+// loginWait はユーザー名とIPアドレスの直近の失敗から、次に試行できるまでの待ち時間を返す。
+// 0 の場合はすぐに試行できる。
+func loginWait(tx *sql.Tx, username, ipAddress string) (time.Duration, error) {
+	now := time.Now()
+
+	// ユーザー名ごとの失敗は、そのユーザーが最後にログインに成功した後のものだけを数える
+	var userFailures int
+	var userLast sql.NullTime
+	err := tx.QueryRow(`
+		SELECT COUNT(*), MAX(created_at) FROM login_attempts
+		WHERE username = $1 AND NOT succeeded AND reason <> $2 AND created_at > $3
+		  AND created_at > COALESCE(
+		      (SELECT MAX(created_at) FROM login_attempts WHERE username = $1 AND succeeded),
+		      '-infinity')`,
+		username, LoginFailureThrottled, now.Add(-UserLoginPolicy.LockoutDuration)).
+		Scan(&userFailures, &userLast)
+	if err != nil {
+		return 0, err
+	}
+
+	// IPアドレスごとの失敗は、成功しても数え直さない
+	var ipFailures int
+	var ipLast sql.NullTime
+	err = tx.QueryRow(`
+		SELECT COUNT(*), MAX(created_at) FROM login_attempts
+		WHERE ip_address = $1 AND NOT succeeded AND reason <> $2 AND created_at > $3`,
+		ipAddress, LoginFailureThrottled, now.Add(-IPLoginPolicy.LockoutDuration)).
+		Scan(&ipFailures, &ipLast)
+	if err != nil {
+		return 0, err
+	}
+
+	wait := UserLoginPolicy.Wait(userFailures, userLast.Time, now)
+	if ipWait := IPLoginPolicy.Wait(ipFailures, ipLast.Time, now); ipWait > wait {
+		wait = ipWait
+	}
+	return wait, nil
+}
+
+// Fail はログインの失敗の理由を監査用に記録し、試行を終了する
+func (a *LoginAttempt) Fail(reason string) error {
+	if a.done {
+		return nil
+	}
+	a.done = true
+	_, err := a.db.Exec(`UPDATE login_attempts SET reason = $2 WHERE id = $1`, a.id, reason)
+	return err
+}
+
+// Close は失敗を記録せずに試行を終了し、開始時の記録を取り消す。Fail の後に呼んでもよい。
+func (a *LoginAttempt) Close() error {
+	if a.done {
+		return nil
+	}
+	a.done = true
+	_, err := a.db.Exec(`DELETE FROM login_attempts WHERE id = $1`, a.id)
+	return err
+}
+
+// RecordSuccess はログインの成功を記録する。ユーザー名ごとの失敗回数はここで数え直される。
+func (s *LoginAttemptStore) RecordSuccess(username, ipAddress, userAgent string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO login_attempts (username, ip_address, user_agent, succeeded, reason)
+		VALUES ($1, $2, $3, TRUE, '')`,
+		truncateAttemptUsername(username), ipAddress, userAgent)
+	return err
+}
+
+func truncateAttemptUsername(username string) string {
+	if utf8.RuneCountInString(username) <= maxAttemptUsernameSize {
+		return username
+	}
+	return string([]rune(username)[:maxAttemptUsernameSize])
+}
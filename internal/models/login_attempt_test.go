@@ -0,0 +1,148 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoginPolicy_Wait(t *testing.T) {
+	policy := LoginPolicy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         30 * time.Second,
+		LockoutThreshold: 10,
+		LockoutDuration:  15 * time.Minute,
+	}
+	last := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		failures int
+		now      time.Time
+		want     time.Duration
+	}{
+		{0, last, 0},
+		{3, last, 0},
+		{4, last, time.Second},
+		{5, last, 2 * time.Second},
+		{6, last.Add(time.Second), 3 * time.Second},
+		{9, last, 30 * time.Second},
+		{10, last.Add(5 * time.Minute), 10 * time.Minute},
+		{10, last.Add(15 * time.Minute), 0},
+	}
+
+	for _, tt := range tests {
+		if got := policy.Wait(tt.failures, last, tt.now); got != tt.want {
+			t.Errorf("失敗%d回の待ち時間が%vであるべきですが、実際は%vです", tt.failures, tt.want, got)
+		}
+	}
+}
+
+// recordAttemptFailure はログインの試行を開始して失敗を記録する。待ち時間中の場合は Begin が試行を記録する。
+func recordAttemptFailure(t *testing.T, store *LoginAttemptStore, username, ipAddress, reason string) {
+	t.Helper()
+	attempt, _, err := store.Begin(username, ipAddress, "test-agent")
+	if err != nil {
+		t.Fatalf("ログインの試行を開始できません: %v", err)
+	}
+	if err := attempt.Fail(reason); err != nil {
+		t.Fatalf("ログイン失敗の記録に失敗しました: %v", err)
+	}
+}
+
+// attemptWait はログインの試行を開始して待ち時間を返す。試行は失敗を記録せずに終了する。
+func attemptWait(t *testing.T, store *LoginAttemptStore, username, ipAddress string) time.Duration {
+	t.Helper()
+	attempt, wait, err := store.Begin(username, ipAddress, "test-agent")
+	if err != nil {
+		t.Fatalf("ログインの試行を開始できません: %v", err)
+	}
+	if err := attempt.Close(); err != nil {
+		t.Fatalf("ログインの試行を終了できません: %v", err)
+	}
+	return wait
+}
+
+func TestLoginAttemptStore_Wait(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewLoginAttemptStore(db)
+
+	for i := 0; i < UserLoginPolicy.FreeAttempts; i++ {
+		recordAttemptFailure(t, store, "testuser", "192.0.2.1", LoginFailureInvalidPassword)
+	}
+	if wait := attemptWait(t, store, "testuser", "192.0.2.1"); wait != 0 {
+		t.Fatalf("規定回数以内の失敗では待ち時間がないはずですが、実際は%vです", wait)
+	}
+
+	// 規定回数を超えると待ち時間が発生する
+	recordAttemptFailure(t, store, "testuser", "192.0.2.2", LoginFailureInvalidPassword)
+	wait := attemptWait(t, store, "testuser", "198.51.100.1")
+	if wait <= 0 {
+		t.Error("ユーザー名ごとの失敗回数に応じた待ち時間が発生していません")
+	}
+
+	// 待ち時間中の試行は失敗回数に数えない
+	recordAttemptFailure(t, store, "testuser", "192.0.2.1", LoginFailureThrottled)
+	if again := attemptWait(t, store, "testuser", "198.51.100.1"); again > wait {
+		t.Errorf("待ち時間中の試行で待ち時間が延びています: %v → %v", wait, again)
+	}
+
+	// ログインに成功するとユーザー名ごとの失敗回数は数え直される
+	if err := store.RecordSuccess("testuser", "192.0.2.1", "test-agent"); err != nil {
+		t.Fatalf("ログイン成功の記録に失敗しました: %v", err)
+	}
+	if wait := attemptWait(t, store, "testuser", "198.51.100.1"); wait != 0 {
+		t.Errorf("ログイン成功後も待ち時間が残っています: %v", wait)
+	}
+}
+
+// 並行した試行はそれぞれ開始時に失敗として数えられ、結果が決まる前でも規定回数を超えた試行は待たされる
+func TestLoginAttemptStore_Concurrent(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewLoginAttemptStore(db)
+
+	const attempts = 6
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var started []*LoginAttempt
+	throttled := 0
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			attempt, wait, err := store.Begin("testuser", fmt.Sprintf("192.0.2.%d", i+1), "test-agent")
+			if err != nil {
+				t.Errorf("ログインの試行を開始できません: %v", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if wait > 0 {
+				throttled++
+			} else {
+				started = append(started, attempt)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(started) != UserLoginPolicy.FreeAttempts+1 || throttled != attempts-len(started) {
+		t.Errorf("待ち時間なしで始まった試行は%d件であるべきですが、実際は%d件です（待ち時間中: %d件）",
+			UserLoginPolicy.FreeAttempts+1, len(started), throttled)
+	}
+
+	// 失敗を記録せずに終了した試行は数えない
+	for _, attempt := range started {
+		if err := attempt.Close(); err != nil {
+			t.Fatalf("ログインの試行を終了できません: %v", err)
+		}
+	}
+	if wait := attemptWait(t, store, "testuser", "198.51.100.1"); wait != 0 {
+		t.Errorf("終了した試行が失敗として数えられています: %v", wait)
+	}
+}
@@ -2,8 +2,10 @@ package models
 
 import (
 	"database/sql"
-	"errors"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 type Message struct {
@@ -14,6 +16,16 @@ type Message struct {
 	Username  string    `json:"username"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// UpdatedBy は投稿者以外（モデレーター）が最後に編集した場合の編集者のユーザー名
+	UpdatedBy string `json:"updated_by,omitempty"`
+	// Version は更新のたびに1ずつ増える版番号。同時に編集した場合の上書きを防ぐために使う。
+	Version int `json:"version"`
+	// HiddenAt は通報への対応としてモデレーターが非表示にした日時
+	HiddenAt *time.Time `json:"hidden_at,omitempty"`
+	// DeletedAt はゴミ箱に移動した日時。ゴミ箱の一覧でのみ設定される。
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Snippet は検索結果でヒット箇所を<mark>で囲んだエスケープ済みHTML
+	Snippet string `json:"snippet,omitempty"`
 }
 
 type MessageStore struct {
@@ -27,16 +39,17 @@ func NewMessageStore(db *sql.DB) *MessageStore {
 func (s *MessageStore) List(page, perPage int) ([]Message, int, error) {
 	// 総数を取得
 	var total int
-	err := s.db.QueryRow("SELECT COUNT(*) FROM messages").Scan(&total)
+	err := s.db.QueryRow("SELECT COUNT(*) FROM messages WHERE hidden_at IS NULL AND deleted_at IS NULL").Scan(&total)
 	if err != nil {
 		return nil, 0, err
 	}
 
 	offset := (page - 1) * perPage
 	rows, err := s.db.Query(`
-		SELECT m.id, m.title, m.content, m.user_id, u.username, m.created_at, m.updated_at 
+		SELECT m.id, m.title, m.content, COALESCE(m.user_id, 0), COALESCE(u.username, ''), m.created_at, m.updated_at 
 		FROM messages m
 		LEFT JOIN users u ON m.user_id = u.id
+		WHERE m.hidden_at IS NULL AND m.deleted_at IS NULL
 		ORDER BY m.created_at DESC 
 		LIMIT $1 OFFSET $2`, perPage, offset)
 	if err != nil {
@@ -59,12 +72,13 @@ func (s *MessageStore) List(page, perPage int) ([]Message, int, error) {
 func (s *MessageStore) Get(id int) (*Message, error) {
 	var m Message
 	err := s.db.QueryRow(`
-		SELECT m.id, m.title, m.content, m.user_id, u.username, m.created_at, m.updated_at 
+		SELECT m.id, m.title, m.content, COALESCE(m.user_id, 0), COALESCE(u.username, ''), m.created_at, m.updated_at, COALESCE(e.username, ''), m.version, m.hidden_at
 		FROM messages m
 		LEFT JOIN users u ON m.user_id = u.id
-		WHERE m.id = $1`, id).Scan(&m.ID, &m.Title, &m.Content, &m.UserID, &m.Username, &m.CreatedAt, &m.UpdatedAt)
+		LEFT JOIN users e ON m.updated_by = e.id
+		WHERE m.id = $1 AND m.deleted_at IS NULL`, id).Scan(&m.ID, &m.Title, &m.Content, &m.UserID, &m.Username, &m.CreatedAt, &m.UpdatedAt, &m.UpdatedBy, &m.Version, &m.HiddenAt)
 	if err == sql.ErrNoRows {
-		return nil, errors.New("message not found")
+		return nil, notFoundError("message not found")
 	}
 	if err != nil {
 		return nil, err
@@ -72,53 +86,172 @@ func (s *MessageStore) Get(id int) (*Message, error) {
 	return &m, nil
 }
 
-func (s *MessageStore) Create(title, content string, userID int) error {
-	_, err := s.db.Exec(`
+// Create はメッセージを作成し、そのIDを返す
+func (s *MessageStore) Create(title, content string, userID int) (int, error) {
+	var id int
+	err := s.db.QueryRow(`
 		INSERT INTO messages (title, content, user_id) 
-		VALUES ($1, $2, $3)`, title, content, userID)
-	return err
+		VALUES ($1, $2, $3)
+		RETURNING id`, title, content, userID).Scan(&id)
+	return id, err
 }
 
-func (s *MessageStore) Update(id int, title, content string, userID int) error {
-	// まずメッセージがそのユーザーに属しているかチェック
-	var messageUserID int
-	err := s.db.QueryRow("SELECT user_id FROM messages WHERE id = $1", id).Scan(&messageUserID)
+// Update はメッセージを更新する。投稿者本人かモデレーションの権限を持つユーザーのみ更新できる。
+// version は編集を始めたときの版番号で、その後に他の更新があった場合は "version conflict" エラーを返す。
+// 更新前のタイトルと内容は編集履歴として残す。
+// 他のユーザーのメッセージを更新した場合は更新者として記録し、モデレーションログに残す。
+func (s *MessageStore) Update(id int, title, content string, userID, version int) error {
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
-	if messageUserID != userID {
-		return errors.New("unauthorized: message belongs to another user")
-	}
+	defer tx.Rollback()
 
-	result, err := s.db.Exec(`
-		UPDATE messages 
-		SET title = $1, content = $2, updated_at = CURRENT_TIMESTAMP 
-		WHERE id = $3 AND user_id = $4`, title, content, id, userID)
+	ownerID, allowed, err := authorizeModification(tx, "messages", id, userID)
+	if err == sql.ErrNoRows {
+		return notFoundError("message not found")
+	}
 	if err != nil {
 		return err
 	}
-	rows, err := result.RowsAffected()
+	if !allowed {
+		return forbiddenError("unauthorized: message belongs to another user")
+	}
+
+	// 行は authorizeModification でロックしているため、確認から更新までの間に版は変わらない
+	var current int
+	if err := tx.QueryRow(`SELECT version FROM messages WHERE id = $1`, id).Scan(&current); err != nil {
+		return err
+	}
+	if current != version {
+		return conflictError("version conflict")
+	}
+
+	if err := saveRevision(tx, id); err != nil {
+		return err
+	}
+
+	// 投稿者本人による更新では更新者を記録しない
+	_, err = tx.Exec(`
+		UPDATE messages
+		SET title = $1, content = $2, updated_at = CURRENT_TIMESTAMP,
+		    updated_by = NULLIF($4, user_id), version = version + 1
+		WHERE id = $3`, title, content, id, userID)
 	if err != nil {
 		return err
 	}
-	if rows == 0 {
-		return errors.New("message not found")
+	if ownerID != userID {
+		if err := logModeration(tx, userID, ModerationEditMessage, id, ownerID, title); err != nil {
+			return err
+		}
 	}
-	return nil
+	return tx.Commit()
 }
 
+// Delete はメッセージをゴミ箱に移動する。投稿者本人かモデレーションの権限を持つユーザーのみ削除できる。
+// ゴミ箱のメッセージは一覧・取得・検索の対象から外れ、保存期間を過ぎると Purge で完全に削除される。
+// 他のユーザーのメッセージを削除した場合は、タイトルとともにモデレーションログに残す。
+// モデレーターが削除したメッセージは投稿者のゴミ箱には表示されず、復元できない。
 func (s *MessageStore) Delete(id int, userID int) error {
-	// まずメッセージがそのユーザーに属しているかチェック
-	var messageUserID int
-	err := s.db.QueryRow("SELECT user_id FROM messages WHERE id = $1", id).Scan(&messageUserID)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ownerID, allowed, err := authorizeModification(tx, "messages", id, userID)
+	if err == sql.ErrNoRows {
+		return notFoundError("message not found")
+	}
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return forbiddenError("unauthorized: message belongs to another user")
+	}
+
+	var title string
+	err = tx.QueryRow(`
+		UPDATE messages SET deleted_at = CURRENT_TIMESTAMP, deleted_by = $2
+		WHERE id = $1
+		RETURNING title`, id, userID).Scan(&title)
+	if err != nil {
+		return err
+	}
+	if ownerID != userID {
+		if err := logModeration(tx, userID, ModerationDeleteMessage, id, ownerID, title); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Unhide はモデレーターが非表示にしたメッセージを再び表示し、モデレーションログに残す
+func (s *MessageStore) Unhide(id, actorID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var ownerID sql.NullInt64
+	var title string
+	err = tx.QueryRow(`
+		UPDATE messages SET hidden_at = NULL
+		WHERE id = $1 AND hidden_at IS NOT NULL AND deleted_at IS NULL
+		RETURNING user_id, title`, id).Scan(&ownerID, &title)
+	if err == sql.ErrNoRows {
+		return notFoundError("message not found")
+	}
 	if err != nil {
 		return err
 	}
-	if messageUserID != userID {
-		return errors.New("unauthorized: message belongs to another user")
+	if err := logModeration(tx, actorID, ModerationUnhideMessage, id, int(ownerID.Int64), title); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Trash はユーザーが自分で削除したゴミ箱のメッセージを、削除の新しい順に1ページ分と総数を返す
+func (s *MessageStore) Trash(userID, page, perPage int) ([]Message, int, error) {
+	var total int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM messages
+		WHERE user_id = $1 AND deleted_by = $1 AND deleted_at IS NOT NULL`, userID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * perPage
+	rows, err := s.db.Query(`
+		SELECT m.id, m.title, m.content, m.user_id, u.username, m.created_at, m.updated_at, m.deleted_at
+		FROM messages m
+		JOIN users u ON m.user_id = u.id
+		WHERE m.user_id = $1 AND m.deleted_by = $1 AND m.deleted_at IS NOT NULL
+		ORDER BY m.deleted_at DESC
+		LIMIT $2 OFFSET $3`, userID, perPage, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var m Message
+		err := rows.Scan(&m.ID, &m.Title, &m.Content, &m.UserID, &m.Username, &m.CreatedAt, &m.UpdatedAt, &m.DeletedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		messages = append(messages, m)
 	}
+	return messages, total, rows.Err()
+}
 
-	result, err := s.db.Exec(`DELETE FROM messages WHERE id = $1 AND user_id = $2`, id, userID)
+// Restore はユーザーが自分で削除したメッセージをゴミ箱から元に戻す
+func (s *MessageStore) Restore(id, userID int) error {
+	result, err := s.db.Exec(`
+		UPDATE messages SET deleted_at = NULL, deleted_by = NULL
+		WHERE id = $1 AND user_id = $2 AND deleted_by = $2 AND deleted_at IS NOT NULL`, id, userID)
 	if err != nil {
 		return err
 	}
@@ -127,31 +260,132 @@ func (s *MessageStore) Delete(id int, userID int) error {
 		return err
 	}
 	if rows == 0 {
-		return errors.New("message not found")
+		return notFoundError("message not found")
 	}
 	return nil
 }
 
-func (s *MessageStore) Search(query string) ([]Message, error) {
+// Purge は before より前にゴミ箱に移動したメッセージを完全に削除し、削除した件数を返す。
+// 投稿と通報は外部キーの ON DELETE CASCADE で削除される。
+func (s *MessageStore) Purge(before time.Time) (int64, error) {
+	result, err := s.db.Exec(`DELETE FROM messages WHERE deleted_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Search は検索条件に一致するメッセージを1ページ分とヒット総数を返す。
+// 全文検索モードのクエリは websearch_to_tsquery で解釈されるため、"フレーズ"、-除外、OR が使える。
+// 部分一致モードは pg_trgm のインデックスを使い、分かち書きされない日本語の文中の語も見つけられる。
+func (s *MessageStore) Search(opts SearchOptions, page, perPage int) ([]Message, int, error) {
+	// 非表示のメッセージとゴミ箱のメッセージは検索結果に含めない
+	conds := []string{"m.hidden_at IS NULL", "m.deleted_at IS NULL"}
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	// 検索語の条件
+	var queryParam string
+	if opts.Query != "" {
+		if opts.Mode == SearchModeTrigram {
+			p := arg("%" + escapeLike(opts.Query) + "%")
+			conds = append(conds, "(m.title ILIKE "+p+" OR m.content ILIKE "+p+")")
+		} else {
+			queryParam = arg(opts.Query)
+			conds = append(conds, "m.search_vector @@ websearch_to_tsquery('simple', "+queryParam+")")
+		}
+	}
+
+	// 絞り込み条件
+	if opts.Author != "" {
+		conds = append(conds, "u.username = "+arg(opts.Author))
+	}
+	if !opts.From.IsZero() {
+		conds = append(conds, "m.created_at >= "+arg(opts.From))
+	}
+	if !opts.To.IsZero() {
+		// 終了日はその日の終わりまで含める
+		conds = append(conds, "m.created_at < "+arg(opts.To.AddDate(0, 0, 1)))
+	}
+	if opts.EditedOnly {
+		conds = append(conds, "m.updated_at <> m.created_at")
+	}
+
+	where := "WHERE " + strings.Join(conds, " AND ")
+
+	// ヒット総数を取得
+	var total int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM messages m
+		LEFT JOIN users u ON m.user_id = u.id
+		`+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// 関連度とスニペット
+	relevance := opts.Sort == "" || opts.Sort == SearchSortRelevance
+	snippet := "''"
+	rank := ""
+	if opts.Query != "" {
+		if opts.Mode == SearchModeTrigram {
+			// トライグラムを作れない短いクエリは類似度を計算せず新しい順に並べる
+			if relevance && utf8.RuneCountInString(opts.Query) >= trigramMinLength {
+				q := arg(opts.Query)
+				rank = "GREATEST(similarity(m.title, " + q + "), similarity(m.content, " + q + "))"
+			}
+		} else {
+			q := "websearch_to_tsquery('simple', " + queryParam + ")"
+			rank = "ts_rank(m.search_vector, " + q + ")"
+			snippet = "ts_headline('simple', m.content, " + q + ", " + arg(headlineOptions) + ")"
+		}
+	}
+
+	var orderBy string
+	switch opts.Sort {
+	case SearchSortOldest:
+		orderBy = "m.created_at ASC"
+	case SearchSortUpdated:
+		orderBy = "m.updated_at DESC"
+	case SearchSortNewest:
+		orderBy = "m.created_at DESC"
+	default:
+		orderBy = "m.created_at DESC"
+		if rank != "" {
+			orderBy = rank + " DESC, m.created_at DESC"
+		}
+	}
+
+	offset := (page - 1) * perPage
+	limit := arg(perPage)
 	rows, err := s.db.Query(`
-		SELECT m.id, m.title, m.content, m.user_id, u.username, m.created_at, m.updated_at 
+		SELECT m.id, m.title, m.content, COALESCE(m.user_id, 0), COALESCE(u.username, ''), m.created_at, m.updated_at, `+snippet+`
 		FROM messages m
 		LEFT JOIN users u ON m.user_id = u.id
-		WHERE m.title ILIKE $1 OR m.content ILIKE $1 
-		ORDER BY m.created_at DESC`, "%"+query+"%")
+		`+where+`
+		ORDER BY `+orderBy+`
+		LIMIT `+limit+` OFFSET `+arg(offset), args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
 	var messages []Message
 	for rows.Next() {
 		var m Message
-		err := rows.Scan(&m.ID, &m.Title, &m.Content, &m.UserID, &m.Username, &m.CreatedAt, &m.UpdatedAt)
+		var snippet string
+		err := rows.Scan(&m.ID, &m.Title, &m.Content, &m.UserID, &m.Username, &m.CreatedAt, &m.UpdatedAt, &snippet)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
+		}
+		if opts.Query != "" && opts.Mode == SearchModeTrigram {
+			snippet = markSubstring(m.Content, opts.Query)
 		}
+		m.Snippet = highlightSnippet(snippet)
 		messages = append(messages, m)
 	}
-	return messages, nil
+	return messages, total, nil
 }
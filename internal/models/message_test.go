@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	_ "github.com/lib/pq"
 )
@@ -38,6 +39,19 @@ func setupTestDB(t *testing.T) (*sql.DB, func()) {
 
 	// テストデータベースの初期化
 	_, err = db.Exec(`
+		CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+		DROP TABLE IF EXISTS message_revisions;
+		DROP TABLE IF EXISTS reports;
+		DROP TABLE IF EXISTS moderation_log;
+		DROP TABLE IF EXISTS identities;
+		DROP TABLE IF EXISTS login_attempts;
+		DROP TABLE IF EXISTS recovery_codes;
+		DROP TABLE IF EXISTS email_verifications;
+		DROP TABLE IF EXISTS password_resets;
+		DROP TABLE IF EXISTS sessions;
+		DROP TABLE IF EXISTS tokens;
+		DROP TABLE IF EXISTS posts;
 		DROP TABLE IF EXISTS messages;
 		DROP TABLE IF EXISTS users;
 		
@@ -45,16 +59,162 @@ func setupTestDB(t *testing.T) (*sql.DB, func()) {
 			id SERIAL PRIMARY KEY,
 			username VARCHAR(50) NOT NULL UNIQUE,
 			password_hash VARCHAR(255) NOT NULL,
+			email VARCHAR(254),
+			email_verified_at TIMESTAMP WITH TIME ZONE,
+			totp_secret VARCHAR(64),
+			totp_enabled_at TIMESTAMP WITH TIME ZONE,
+			totp_last_step BIGINT,
+			role VARCHAR(20) NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin')),
+			suspended_at TIMESTAMP WITH TIME ZONE,
+			suspended_until TIMESTAMP WITH TIME ZONE,
+			suspension_reason TEXT,
 			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 		);
 
+		CREATE UNIQUE INDEX users_email_idx ON users (lower(email)) WHERE email_verified_at IS NOT NULL;
+
 		CREATE TABLE messages (
 			id SERIAL PRIMARY KEY,
 			title VARCHAR(20) NOT NULL,
 			content TEXT NOT NULL,
 			user_id INTEGER REFERENCES users(id),
 			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			updated_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			version INTEGER NOT NULL DEFAULT 1,
+			hidden_at TIMESTAMP WITH TIME ZONE,
+			deleted_at TIMESTAMP WITH TIME ZONE,
+			deleted_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			search_vector tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('simple', coalesce(title, '')), 'A') ||
+				setweight(to_tsvector('simple', coalesce(content, '')), 'B')
+			) STORED
+		);
+
+		CREATE INDEX messages_search_vector_idx ON messages USING GIN (search_vector);
+		CREATE INDEX messages_title_trgm_idx ON messages USING GIN (title gin_trgm_ops);
+		CREATE INDEX messages_content_trgm_idx ON messages USING GIN (content gin_trgm_ops);
+
+		CREATE TABLE posts (
+			id SERIAL PRIMARY KEY,
+			message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			parent_post_id INTEGER REFERENCES posts(id) ON DELETE CASCADE,
+			content TEXT NOT NULL,
+			user_id INTEGER REFERENCES users(id),
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			updated_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			deleted_at TIMESTAMP WITH TIME ZONE
+		);
+
+		CREATE TABLE tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(100) NOT NULL,
+			token_hash CHAR(64) NOT NULL UNIQUE,
+			hint VARCHAR(16) NOT NULL,
+			scopes TEXT[] NOT NULL,
+			last_used_at TIMESTAMP WITH TIME ZONE,
+			expires_at TIMESTAMP WITH TIME ZONE,
+			revoked_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE sessions (
+			id CHAR(32) PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			refresh_token_hash CHAR(64) NOT NULL,
+			previous_refresh_token_hash CHAR(64),
+			rotated_at TIMESTAMP WITH TIME ZONE,
+			user_agent TEXT NOT NULL DEFAULT '',
+			ip_address VARCHAR(45) NOT NULL DEFAULT '',
+			last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			revoked_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE password_resets (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			token_hash CHAR(64) NOT NULL UNIQUE,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			used_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE email_verifications (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			email VARCHAR(254) NOT NULL,
+			token_hash CHAR(64) NOT NULL UNIQUE,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			used_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE recovery_codes (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			code_hash CHAR(64) NOT NULL,
+			used_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE login_attempts (
+			id BIGSERIAL PRIMARY KEY,
+			username VARCHAR(100) NOT NULL,
+			ip_address VARCHAR(45) NOT NULL,
+			user_agent TEXT NOT NULL DEFAULT '',
+			succeeded BOOLEAN NOT NULL,
+			reason VARCHAR(32) NOT NULL DEFAULT '',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE identities (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			issuer TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			email VARCHAR(254) NOT NULL DEFAULT '',
+			last_login_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (issuer, subject)
+		);
+
+		CREATE TABLE moderation_log (
+			id SERIAL PRIMARY KEY,
+			actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			action VARCHAR(32) NOT NULL,
+			target_id INTEGER NOT NULL,
+			target_user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			details TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE reports (
+			id SERIAL PRIMARY KEY,
+			message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			reporter_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			category VARCHAR(20) NOT NULL CHECK (category IN ('spam', 'harassment', 'inappropriate', 'other')),
+			note TEXT NOT NULL DEFAULT '',
+			resolution VARCHAR(20),
+			resolved_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			resolved_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE UNIQUE INDEX reports_open_idx ON reports (message_id, reporter_id) WHERE resolved_at IS NULL;
+
+		CREATE TABLE message_revisions (
+			id SERIAL PRIMARY KEY,
+			message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			revision INTEGER NOT NULL,
+			title VARCHAR(20) NOT NULL,
+			content TEXT NOT NULL,
+			edited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			UNIQUE (message_id, revision)
 		);
 	`)
 	if err != nil {
@@ -79,7 +239,7 @@ func TestMessageStore_Create(t *testing.T) {
 	}
 
 	// メッセージの作成
-	err = store.Create("テストタイトル", "テスト内容", 1)
+	_, err = store.Create("テストタイトル", "テスト内容", 1)
 	if err != nil {
 		t.Errorf("メッセージの作成に失敗しました: %v", err)
 	}
@@ -177,7 +337,7 @@ func TestMessageStore_Update(t *testing.T) {
 	}
 
 	// メッセージの更新
-	err = store.Update(1, "新タイトル", "新内容", 1)
+	err = store.Update(1, "新タイトル", "新内容", 1, 1)
 	if err != nil {
 		t.Errorf("メッセージの更新に失敗しました: %v", err)
 	}
@@ -196,6 +356,50 @@ func TestMessageStore_Update(t *testing.T) {
 	}
 }
 
+func TestMessageStore_UpdateConflict(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewMessageStore(db)
+
+	_, err := db.Exec(`
+		INSERT INTO users (id, username, password_hash) VALUES (1, 'testuser', 'testhash');
+		INSERT INTO messages (id, title, content, user_id) VALUES (1, '元のタイトル', '元の内容', 1);
+	`)
+	if err != nil {
+		t.Fatalf("テストデータの作成に失敗しました: %v", err)
+	}
+
+	// 2つのタブで同じ版を編集し、先に保存した方だけが反映される
+	msg, err := store.Get(1)
+	if err != nil {
+		t.Fatalf("メッセージの取得に失敗しました: %v", err)
+	}
+	if msg.Version != 1 {
+		t.Fatalf("作成直後の版番号が1であるべきですが、実際は%dです", msg.Version)
+	}
+	if err := store.Update(1, "タブ1", "タブ1の内容", 1, msg.Version); err != nil {
+		t.Fatalf("メッセージの更新に失敗しました: %v", err)
+	}
+	err = store.Update(1, "タブ2", "タブ2の内容", 1, msg.Version)
+	if err == nil || err.Error() != "version conflict" {
+		t.Errorf("'version conflict'エラーを期待しますが、実際は'%v'です", err)
+	}
+
+	msg, err = store.Get(1)
+	if err != nil {
+		t.Fatalf("メッセージの取得に失敗しました: %v", err)
+	}
+	if msg.Title != "タブ1" || msg.Version != 2 {
+		t.Errorf("先に保存した内容（版番号2）であるべきですが、実際は'%s'（版番号%d）です", msg.Title, msg.Version)
+	}
+
+	// 最新の版番号を指定すれば保存できる
+	if err := store.Update(1, "タブ2", "タブ2の内容", 1, msg.Version); err != nil {
+		t.Errorf("最新の版番号での更新に失敗しました: %v", err)
+	}
+}
+
 func TestMessageStore_Delete(t *testing.T) {
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -211,13 +415,58 @@ func TestMessageStore_Delete(t *testing.T) {
 		t.Fatalf("テストデータの作成に失敗しました: %v", err)
 	}
 
-	// メッセージの削除
+	// メッセージの削除（ゴミ箱への移動）
 	err = store.Delete(1, 1)
 	if err != nil {
 		t.Errorf("メッセージの削除に失敗しました: %v", err)
 	}
 
-	// メッセージの確認
+	// 削除したメッセージは取得できず、一覧にも含まれない
+	if _, err := store.Get(1); err == nil || err.Error() != "message not found" {
+		t.Errorf("'message not found'エラーを期待しますが、実際は'%v'です", err)
+	}
+	if _, total, _ := store.List(1, 10); total != 0 {
+		t.Errorf("削除したメッセージが一覧に含まれています: %d件", total)
+	}
+	err = store.Delete(1, 1)
+	if err == nil || err.Error() != "message not found" {
+		t.Errorf("削除済みのメッセージを再度削除した場合は'message not found'エラーを期待しますが、実際は'%v'です", err)
+	}
+
+	// ゴミ箱に表示され、元に戻せる
+	trash, total, err := store.Trash(1, 1, 10)
+	if err != nil {
+		t.Fatalf("ゴミ箱の取得に失敗しました: %v", err)
+	}
+	if total != 1 || len(trash) != 1 || trash[0].DeletedAt == nil {
+		t.Fatalf("ゴミ箱に1件のメッセージがあるべきですが、実際は%d件です", total)
+	}
+	if err := store.Restore(1, 1); err != nil {
+		t.Fatalf("メッセージの復元に失敗しました: %v", err)
+	}
+	if _, err := store.Get(1); err != nil {
+		t.Errorf("復元したメッセージを取得できません: %v", err)
+	}
+
+	// 保存期間を過ぎたゴミ箱のメッセージは完全に削除される
+	if err := store.Delete(1, 1); err != nil {
+		t.Fatalf("メッセージの削除に失敗しました: %v", err)
+	}
+	purged, err := store.Purge(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("完全削除に失敗しました: %v", err)
+	}
+	if purged != 0 {
+		t.Errorf("保存期間内のメッセージが完全に削除されました: %d件", purged)
+	}
+	purged, err = store.Purge(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("完全削除に失敗しました: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("1件が完全に削除されるべきですが、実際は%d件です", purged)
+	}
+
 	var count int
 	err = db.QueryRow("SELECT COUNT(*) FROM messages WHERE id = 1").Scan(&count)
 	if err != nil {
@@ -228,6 +477,81 @@ func TestMessageStore_Delete(t *testing.T) {
 	}
 }
 
+func TestMessageStore_Moderation(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewMessageStore(db)
+
+	// 投稿者、一般ユーザー、モデレーターとメッセージの作成
+	_, err := db.Exec(`
+		INSERT INTO users (id, username, password_hash) VALUES (1, 'owner', 'testhash');
+		INSERT INTO users (id, username, password_hash) VALUES (2, 'other', 'testhash');
+		INSERT INTO users (id, username, password_hash, role) VALUES (3, 'moderator', 'testhash', 'moderator');
+		INSERT INTO messages (id, title, content, user_id) VALUES (1, '元のタイトル', '元の内容', 1);
+	`)
+	if err != nil {
+		t.Fatalf("テストデータの作成に失敗しました: %v", err)
+	}
+
+	// 一般ユーザーは他のユーザーのメッセージを編集・削除できない
+	err = store.Update(1, "新タイトル", "新内容", 2, 1)
+	if err == nil || err.Error() != "unauthorized: message belongs to another user" {
+		t.Errorf("権限エラーを期待しますが、実際は'%v'です", err)
+	}
+	err = store.Delete(1, 2)
+	if err == nil || err.Error() != "unauthorized: message belongs to another user" {
+		t.Errorf("権限エラーを期待しますが、実際は'%v'です", err)
+	}
+
+	// モデレーターは編集でき、編集者として記録される
+	if err := store.Update(1, "新タイトル", "新内容", 3, 1); err != nil {
+		t.Fatalf("モデレーターによる更新に失敗しました: %v", err)
+	}
+	msg, err := store.Get(1)
+	if err != nil {
+		t.Fatalf("メッセージの取得に失敗しました: %v", err)
+	}
+	if msg.UserID != 1 {
+		t.Errorf("投稿者が変わってしまいました: %d", msg.UserID)
+	}
+	if msg.UpdatedBy != "moderator" {
+		t.Errorf("編集者が'moderator'であるべきですが、実際は'%s'です", msg.UpdatedBy)
+	}
+
+	// 投稿者本人が編集すると編集者の記録は消える
+	if err := store.Update(1, "再編集", "再編集", 1, 2); err != nil {
+		t.Fatalf("投稿者による更新に失敗しました: %v", err)
+	}
+	if msg, _ := store.Get(1); msg == nil || msg.UpdatedBy != "" {
+		t.Errorf("投稿者による編集後も編集者が記録されています")
+	}
+
+	// モデレーターは削除でき、操作がモデレーションログに残る
+	if err := store.Delete(1, 3); err != nil {
+		t.Fatalf("モデレーターによる削除に失敗しました: %v", err)
+	}
+	var count int
+	err = db.QueryRow(`
+		SELECT COUNT(*) FROM moderation_log
+		WHERE actor_id = 3 AND target_id = 1 AND target_user_id = 1`).Scan(&count)
+	if err != nil {
+		t.Fatalf("モデレーションログの取得に失敗しました: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("モデレーションログが2件であるべきですが、実際は%d件です", count)
+	}
+
+	// モデレーターが削除したメッセージは投稿者のゴミ箱に表示されず、復元もできない
+	if _, total, _ := store.Trash(1, 1, 10); total != 0 {
+		t.Errorf("モデレーターが削除したメッセージがゴミ箱に表示されています: %d件", total)
+	}
+	err = store.Restore(1, 1)
+	if err == nil || err.Error() != "message not found" {
+		t.Errorf("'message not found'エラーを期待しますが、実際は'%v'です", err)
+	}
+}
+
 func TestMessageStore_Search(t *testing.T) {
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -238,8 +562,8 @@ func TestMessageStore_Search(t *testing.T) {
 	_, err := db.Exec(`
 		INSERT INTO users (id, username, password_hash) VALUES (1, 'testuser', 'testhash');
 		INSERT INTO messages (title, content, user_id) VALUES 
-			('テストタイトル1', 'テスト内容ABC', 1),
-			('ABCタイトル2', 'テスト内容2', 1),
+			('テストタイトル1', 'テスト内容 ABC', 1),
+			('ABC タイトル2', 'テスト内容2', 1),
 			('テストタイトル3', 'テスト内容3', 1);
 	`)
 	if err != nil {
@@ -247,13 +571,145 @@ func TestMessageStore_Search(t *testing.T) {
 	}
 
 	// メッセージの検索
-	messages, err := store.Search("ABC")
+	messages, total, err := store.Search(SearchOptions{Query: "ABC", Mode: SearchModeFullText}, 1, 10)
 	if err != nil {
 		t.Errorf("メッセージの検索に失敗しました: %v", err)
 	}
 
 	// 結果の確認
+	if total != 2 {
+		t.Errorf("検索結果の総数が2であるべきですが、実際は%dです", total)
+	}
 	if len(messages) != 2 {
-		t.Errorf("検索結果の数が2であるべきですが、実際は%dです", len(messages))
+		t.Fatalf("検索結果の数が2であるべきですが、実際は%dです", len(messages))
+	}
+	// タイトルに一致したメッセージが上位に来る
+	if messages[0].Title != "ABC タイトル2" {
+		t.Errorf("最上位の結果が'ABC タイトル2'であるべきですが、実際は'%s'です", messages[0].Title)
+	}
+	if messages[1].Snippet != "テスト内容 <mark>ABC</mark>" {
+		t.Errorf("スニペットがハイライトされていません: '%s'", messages[1].Snippet)
+	}
+
+	// 除外演算子
+	_, total, err = store.Search(SearchOptions{Query: "ABC -タイトル2", Mode: SearchModeFullText}, 1, 10)
+	if err != nil {
+		t.Errorf("メッセージの検索に失敗しました: %v", err)
+	}
+	if total != 1 {
+		t.Errorf("除外検索の結果が1件であるべきですが、実際は%d件です", total)
+	}
+
+	// ページング
+	messages, total, err = store.Search(SearchOptions{Query: "ABC", Mode: SearchModeFullText}, 2, 1)
+	if err != nil {
+		t.Errorf("メッセージの検索に失敗しました: %v", err)
+	}
+	if total != 2 || len(messages) != 1 {
+		t.Errorf("2ページ目は総数2・1件であるべきですが、実際は総数%d・%d件です", total, len(messages))
+	}
+}
+
+func TestMessageStore_SearchTrigram(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewMessageStore(db)
+
+	// 分かち書きされていない日本語のメッセージ
+	_, err := db.Exec(`
+		INSERT INTO users (id, username, password_hash) VALUES (1, 'testuser', 'testhash');
+		INSERT INTO messages (title, content, user_id) VALUES 
+			('今日の天気', '東京は晴れのち曇りです', 1),
+			('明日の予定', '大阪で晴れ間が見えそう', 1),
+			('お知らせ', '100%達成しました', 1);
+	`)
+	if err != nil {
+		t.Fatalf("テストデータの作成に失敗しました: %v", err)
+	}
+
+	// 文中の語を検索できる
+	messages, total, err := store.Search(SearchOptions{Query: "晴れのち", Mode: SearchModeTrigram}, 1, 10)
+	if err != nil {
+		t.Errorf("メッセージの検索に失敗しました: %v", err)
+	}
+	if total != 1 || len(messages) != 1 {
+		t.Fatalf("検索結果が1件であるべきですが、実際は%d件です", total)
+	}
+	if messages[0].Snippet != "東京は<mark>晴れのち</mark>曇りです" {
+		t.Errorf("スニペットがハイライトされていません: '%s'", messages[0].Snippet)
+	}
+
+	// 2文字のクエリはフォールバックで検索される
+	_, total, err = store.Search(SearchOptions{Query: "晴れ", Mode: SearchModeTrigram}, 1, 10)
+	if err != nil {
+		t.Errorf("メッセージの検索に失敗しました: %v", err)
+	}
+	if total != 2 {
+		t.Errorf("短いクエリの検索結果が2件であるべきですが、実際は%d件です", total)
+	}
+
+	// LIKE の特殊文字はそのまま検索される
+	_, total, err = store.Search(SearchOptions{Query: "%", Mode: SearchModeTrigram}, 1, 10)
+	if err != nil {
+		t.Errorf("メッセージの検索に失敗しました: %v", err)
+	}
+	if total != 1 {
+		t.Errorf("'%%'の検索結果が1件であるべきですが、実際は%d件です", total)
+	}
+}
+
+func TestMessageStore_SearchFilters(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewMessageStore(db)
+
+	_, err := db.Exec(`
+		INSERT INTO users (id, username, password_hash) VALUES (1, 'alice', 'testhash'), (2, 'bob', 'testhash');
+		INSERT INTO messages (title, content, user_id, created_at, updated_at) VALUES 
+			('週報 ABC', '先週の作業', 1, '2024-01-01 10:00:00+00', '2024-01-01 10:00:00+00'),
+			('週報 ABC', '今週の作業', 1, '2024-01-08 10:00:00+00', '2024-01-09 10:00:00+00'),
+			('雑談 ABC', '今週の話題', 2, '2024-01-08 12:00:00+00', '2024-01-08 12:00:00+00');
+	`)
+	if err != nil {
+		t.Fatalf("テストデータの作成に失敗しました: %v", err)
+	}
+
+	// 投稿者で絞り込み
+	_, total, err := store.Search(SearchOptions{Query: "ABC", Author: "alice"}, 1, 10)
+	if err != nil {
+		t.Fatalf("メッセージの検索に失敗しました: %v", err)
+	}
+	if total != 2 {
+		t.Errorf("aliceの検索結果が2件であるべきですが、実際は%d件です", total)
+	}
+
+	// 期間で絞り込み（終了日は当日を含む）
+	from := time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)
+	_, total, err = store.Search(SearchOptions{From: from, To: from}, 1, 10)
+	if err != nil {
+		t.Fatalf("メッセージの検索に失敗しました: %v", err)
+	}
+	if total != 2 {
+		t.Errorf("期間指定の検索結果が2件であるべきですが、実際は%d件です", total)
+	}
+
+	// 編集済みのみ
+	messages, total, err := store.Search(SearchOptions{EditedOnly: true}, 1, 10)
+	if err != nil {
+		t.Fatalf("メッセージの検索に失敗しました: %v", err)
+	}
+	if total != 1 || len(messages) != 1 || messages[0].Content != "今週の作業" {
+		t.Errorf("編集済みのメッセージのみが返されるべきですが、実際は%d件です", total)
+	}
+
+	// 並び順
+	messages, _, err = store.Search(SearchOptions{Query: "ABC", Sort: SearchSortOldest}, 1, 10)
+	if err != nil {
+		t.Fatalf("メッセージの検索に失敗しました: %v", err)
+	}
+	if len(messages) != 3 || messages[0].Content != "先週の作業" {
+		t.Errorf("古い順の最初の結果が'先週の作業'であるべきです")
 	}
 }
@@ -0,0 +1,39 @@
+package models
+
+import "database/sql"
+
+// モデレーションログに記録する操作
+const (
+	ModerationEditMessage   = "message.edit"
+	ModerationDeleteMessage = "message.delete"
+	ModerationEditPost      = "post.edit"
+	ModerationDeletePost    = "post.delete"
+	ModerationUnhideMessage = "message.unhide"
+)
+
+// authorizeModification は table の行 id の投稿者を取得し、actorID のユーザーが編集・削除できるかを返す。
+// 権限の判定には CanModify を使い、役割は常にデータベースの現在の値を参照する。
+// 行をロックするため、トランザクション内で呼び出す。ゴミ箱のメッセージと削除済みの投稿は存在しないものとして扱う。
+func authorizeModification(tx *sql.Tx, table string, id, actorID int) (ownerID int, allowed bool, err error) {
+	var owner sql.NullInt64
+	var role string
+	err = tx.QueryRow(`
+		SELECT t.user_id, COALESCE((SELECT role FROM users WHERE id = $2), 'user')
+		FROM `+table+` t
+		WHERE t.id = $1 AND t.deleted_at IS NULL
+		FOR UPDATE`, id, actorID).Scan(&owner, &role)
+	if err != nil {
+		return 0, false, err
+	}
+	ownerID = int(owner.Int64)
+	return ownerID, CanModify(actorID, Role(role), ownerID), nil
+}
+
+// logModeration はモデレーターが他のユーザーのコンテンツに対して行った操作を記録する。
+// details には削除されたタイトルなど、後から確認するための情報を残す。
+func logModeration(tx *sql.Tx, actorID int, action string, targetID, targetUserID int, details string) error {
+	_, err := tx.Exec(`
+		INSERT INTO moderation_log (actor_id, action, target_id, target_user_id, details)
+		VALUES ($1, $2, $3, NULLIF($4, 0), $5)`, actorID, action, targetID, targetUserID, details)
+	return err
+}
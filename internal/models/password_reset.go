@@ -0,0 +1,99 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordResetStore はパスワード再設定用のトークンを管理する。
+// トークンは一度だけ使え、有効期限を過ぎると使えなくなる。平文のトークンは保存しない。
+type PasswordResetStore struct {
+	db *sql.DB
+}
+
+func NewPasswordResetStore(db *sql.DB) *PasswordResetStore {
+	return &PasswordResetStore{db: db}
+}
+
+// Create はユーザーのパスワード再設定トークンを発行し、平文のトークンを返す
+func (s *PasswordResetStore) Create(userID int, lifetime time.Duration) (string, error) {
+	token, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO password_resets (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)`, userID, hashToken(token), time.Now().Add(lifetime))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Validate はトークンが有効かを確認し、対象のユーザーIDを返す。トークンは消費しない。
+func (s *PasswordResetStore) Validate(token string) (int, error) {
+	var userID int
+	err := s.db.QueryRow(`
+		SELECT user_id FROM password_resets
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP`, hashToken(token)).
+		Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, notFoundError("reset token not found")
+	}
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// Reset はトークンを使用済みにして対象のユーザーのパスワードを変更し、ユーザーIDを返す。
+// 同じユーザーに発行された他の未使用トークンもあわせて無効にする。
+// パスワードの変更に失敗した場合はトークンも消費しない。
+func (s *PasswordResetStore) Reset(token, password string) (int, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(`
+		UPDATE password_resets SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING user_id`, hashToken(token)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, notFoundError("reset token not found")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE password_resets SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND used_at IS NULL`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(`UPDATE users SET password_hash = $1 WHERE id = $2`, string(hashedPassword), userID)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rows == 0 {
+		return 0, notFoundError("user not found")
+	}
+
+	return userID, tx.Commit()
+}
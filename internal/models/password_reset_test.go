@@ -0,0 +1,64 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordResetStore_Reset(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewPasswordResetStore(db)
+	if _, err := db.Exec(`INSERT INTO users (id, username, password_hash) VALUES (1, 'testuser', 'testhash')`); err != nil {
+		t.Fatalf("テストユーザーの作成に失敗しました: %v", err)
+	}
+
+	first, err := store.Create(1, time.Hour)
+	if err != nil {
+		t.Fatalf("再設定トークンの発行に失敗しました: %v", err)
+	}
+	second, err := store.Create(1, time.Hour)
+	if err != nil {
+		t.Fatalf("再設定トークンの発行に失敗しました: %v", err)
+	}
+
+	if userID, err := store.Validate(second); err != nil || userID != 1 {
+		t.Fatalf("有効なトークンの検証に失敗しました: %v", err)
+	}
+
+	userID, err := store.Reset(second, "newpassword")
+	if err != nil {
+		t.Fatalf("再設定トークンの使用に失敗しました: %v", err)
+	}
+	if userID != 1 {
+		t.Errorf("ユーザーIDが1であるべきですが、実際は%dです", userID)
+	}
+	var hash string
+	if err := db.QueryRow(`SELECT password_hash FROM users WHERE id = 1`).Scan(&hash); err != nil {
+		t.Fatalf("パスワードの取得に失敗しました: %v", err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte("newpassword")) != nil {
+		t.Error("パスワードが変更されていません")
+	}
+
+	// 使用済みのトークンは再利用できない
+	if _, err := store.Reset(second, "anotherpassword"); err == nil {
+		t.Error("使用済みのトークンを再利用できてしまいました")
+	}
+	// 同じユーザーの他のトークンも無効になる
+	if _, err := store.Reset(first, "anotherpassword"); err == nil {
+		t.Error("再設定後も古いトークンが有効です")
+	}
+
+	// 期限切れのトークンは使えない
+	expired, err := store.Create(1, -time.Minute)
+	if err != nil {
+		t.Fatalf("再設定トークンの発行に失敗しました: %v", err)
+	}
+	if _, err := store.Validate(expired); err == nil {
+		t.Error("期限切れのトークンが有効と判定されました")
+	}
+}
@@ -0,0 +1,300 @@
+package models
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+type Post struct {
+	ID        int       `json:"id"`
+	MessageID int       `json:"message_id"`
+	ParentID  int       `json:"parent_post_id,omitempty"`
+	Content   string    `json:"content"`
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// UpdatedBy は投稿者以外（モデレーター）が最後に編集した場合の編集者のユーザー名
+	UpdatedBy string `json:"updated_by,omitempty"`
+	// DeletedAt は削除された日時。削除された投稿は返信のツリーを保つため、内容を空にして残す。
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// PostNode はツリー表示用に並べ替えられた投稿とその深さを表す
+type PostNode struct {
+	Post
+	Depth      int    `json:"depth"`
+	ReplyCount int    `json:"reply_count"`
+	ReplyTo    string `json:"reply_to,omitempty"`
+}
+
+type PostStore struct {
+	db *sql.DB
+}
+
+func NewPostStore(db *sql.DB) *PostStore {
+	return &PostStore{db: db}
+}
+
+func (s *PostStore) List(messageID, page, perPage int) ([]Post, int, error) {
+	// スレッド内の投稿総数を取得
+	var total int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM posts WHERE message_id = $1", messageID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * perPage
+	rows, err := s.db.Query(`
+		SELECT p.id, p.message_id, p.parent_post_id, p.content, COALESCE(p.user_id, 0), COALESCE(u.username, ''), p.created_at, p.updated_at, COALESCE(e.username, ''), p.deleted_at
+		FROM posts p
+		LEFT JOIN users u ON p.user_id = u.id
+		LEFT JOIN users e ON p.updated_by = e.id
+		WHERE p.message_id = $1
+		ORDER BY p.created_at ASC, p.id ASC
+		LIMIT $2 OFFSET $3`, messageID, perPage, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	posts, err := scanPosts(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return posts, total, nil
+}
+
+// Tree はスレッドのトップレベル投稿をページ単位で取得し、
+// それぞれの返信ツリーを再帰クエリで展開して深さ付きの順序で返す。
+// 総数はトップレベル投稿の数。
+func (s *PostStore) Tree(messageID, page, perPage, maxDepth int) ([]PostNode, int, error) {
+	total, err := s.RootCount(messageID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * perPage
+	rows, err := s.db.Query(`
+		WITH RECURSIVE roots AS (
+			SELECT id FROM posts
+			WHERE message_id = $1 AND parent_post_id IS NULL
+			ORDER BY created_at ASC, id ASC
+			LIMIT $2 OFFSET $3
+		), tree AS (
+			SELECT p.id FROM posts p JOIN roots r ON p.id = r.id
+			UNION ALL
+			SELECT c.id FROM posts c JOIN tree t ON c.parent_post_id = t.id
+		)
+		SELECT p.id, p.message_id, p.parent_post_id, p.content, COALESCE(p.user_id, 0), COALESCE(u.username, ''), p.created_at, p.updated_at, COALESCE(e.username, ''), p.deleted_at
+		FROM tree t
+		JOIN posts p ON p.id = t.id
+		LEFT JOIN users u ON p.user_id = u.id
+		LEFT JOIN users e ON p.updated_by = e.id`, messageID, perPage, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	posts, err := scanPosts(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return BuildPostTree(posts, maxDepth), total, nil
+}
+
+// RootCount はスレッド内のトップレベル投稿の数を返す
+func (s *PostStore) RootCount(messageID int) (int, error) {
+	var total int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM posts
+		WHERE message_id = $1 AND parent_post_id IS NULL`, messageID).Scan(&total)
+	return total, err
+}
+
+// BuildPostTree は投稿を親子関係に従って深さ優先で並べ替える。
+// 兄弟は投稿日時順に並び、maxDepth より深い返信は maxDepth の深さに平坦化される。
+// 親が含まれていない投稿はトップレベルとして扱う。
+func BuildPostTree(posts []Post, maxDepth int) []PostNode {
+	byID := make(map[int]Post, len(posts))
+	for _, p := range posts {
+		byID[p.ID] = p
+	}
+
+	children := make(map[int][]Post)
+	var roots []Post
+	for _, p := range posts {
+		if _, ok := byID[p.ParentID]; p.ParentID != 0 && ok {
+			children[p.ParentID] = append(children[p.ParentID], p)
+		} else {
+			roots = append(roots, p)
+		}
+	}
+
+	byCreated := func(list []Post) {
+		sort.SliceStable(list, func(i, j int) bool {
+			if list[i].CreatedAt.Equal(list[j].CreatedAt) {
+				return list[i].ID < list[j].ID
+			}
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		})
+	}
+	byCreated(roots)
+
+	nodes := make([]PostNode, 0, len(posts))
+	var walk func(p Post, depth int)
+	walk = func(p Post, depth int) {
+		node := PostNode{Post: p, Depth: depth, ReplyCount: len(children[p.ID])}
+		if depth > maxDepth {
+			node.Depth = maxDepth
+		}
+		if parent, ok := byID[p.ParentID]; ok && p.ParentID != 0 {
+			node.ReplyTo = parent.Username
+		}
+		nodes = append(nodes, node)
+
+		replies := children[p.ID]
+		byCreated(replies)
+		for _, r := range replies {
+			walk(r, depth+1)
+		}
+	}
+	for _, r := range roots {
+		walk(r, 0)
+	}
+	return nodes
+}
+
+func (s *PostStore) Get(id int) (*Post, error) {
+	var p Post
+	var parentID sql.NullInt64
+	err := s.db.QueryRow(`
+		SELECT p.id, p.message_id, p.parent_post_id, p.content, COALESCE(p.user_id, 0), COALESCE(u.username, ''), p.created_at, p.updated_at, COALESCE(e.username, ''), p.deleted_at
+		FROM posts p
+		LEFT JOIN users u ON p.user_id = u.id
+		LEFT JOIN users e ON p.updated_by = e.id
+		WHERE p.id = $1`, id).Scan(&p.ID, &p.MessageID, &parentID, &p.Content, &p.UserID, &p.Username, &p.CreatedAt, &p.UpdatedAt, &p.UpdatedBy, &p.DeletedAt)
+	if err == sql.ErrNoRows {
+		return nil, notFoundError("post not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.ParentID = int(parentID.Int64)
+	return &p, nil
+}
+
+// Create は投稿を作成し、そのIDを返す。parentID が0の場合はトップレベルの投稿になる。
+func (s *PostStore) Create(messageID, parentID int, content string, userID int) (int, error) {
+	var parent sql.NullInt64
+	if parentID != 0 {
+		// 返信先の投稿が同じスレッドに属し、削除されていないかチェック
+		var parentMessageID int
+		err := s.db.QueryRow("SELECT message_id FROM posts WHERE id = $1 AND deleted_at IS NULL", parentID).Scan(&parentMessageID)
+		if err == sql.ErrNoRows || (err == nil && parentMessageID != messageID) {
+			return 0, notFoundError("parent post not found")
+		}
+		if err != nil {
+			return 0, err
+		}
+		parent = sql.NullInt64{Int64: int64(parentID), Valid: true}
+	}
+
+	var id int
+	err := s.db.QueryRow(`
+		INSERT INTO posts (message_id, parent_post_id, content, user_id)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`, messageID, parent, content, userID).Scan(&id)
+	return id, err
+}
+
+// Update は投稿を更新する。投稿者本人かモデレーションの権限を持つユーザーのみ更新できる。
+// 他のユーザーの投稿を更新した場合は更新者として記録し、モデレーションログに残す。
+func (s *PostStore) Update(id int, content string, userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ownerID, allowed, err := authorizeModification(tx, "posts", id, userID)
+	if err == sql.ErrNoRows {
+		return notFoundError("post not found")
+	}
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return forbiddenError("unauthorized: post belongs to another user")
+	}
+
+	// 投稿者本人による更新では更新者を記録しない
+	_, err = tx.Exec(`
+		UPDATE posts
+		SET content = $1, updated_at = CURRENT_TIMESTAMP, updated_by = NULLIF($3, user_id)
+		WHERE id = $2`, content, id, userID)
+	if err != nil {
+		return err
+	}
+	if ownerID != userID {
+		if err := logModeration(tx, userID, ModerationEditPost, id, ownerID, content); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Delete は投稿を削除する。投稿者本人かモデレーションの権限を持つユーザーのみ削除できる。
+// 他のユーザーの返信を消さないよう、投稿は行を残して内容を空にし、削除済みとして表示する。
+// 他のユーザーの投稿を削除した場合は、内容とともにモデレーションログに残す。
+func (s *PostStore) Delete(id int, userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ownerID, allowed, err := authorizeModification(tx, "posts", id, userID)
+	if err == sql.ErrNoRows {
+		return notFoundError("post not found")
+	}
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return forbiddenError("unauthorized: post belongs to another user")
+	}
+
+	var content string
+	if err := tx.QueryRow(`SELECT content FROM posts WHERE id = $1`, id).Scan(&content); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE posts SET content = '', deleted_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if ownerID != userID {
+		if err := logModeration(tx, userID, ModerationDeletePost, id, ownerID, content); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func scanPosts(rows *sql.Rows) ([]Post, error) {
+	var posts []Post
+	for rows.Next() {
+		var p Post
+		var parentID sql.NullInt64
+		err := rows.Scan(&p.ID, &p.MessageID, &parentID, &p.Content, &p.UserID, &p.Username, &p.CreatedAt, &p.UpdatedAt, &p.UpdatedBy, &p.DeletedAt)
+		if err != nil {
+			return nil, err
+		}
+		p.ParentID = int(parentID.Int64)
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
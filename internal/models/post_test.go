@@ -0,0 +1,295 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func setupTestThread(t *testing.T, store *PostStore) {
+	t.Helper()
+	_, err := store.db.Exec(`
+		INSERT INTO users (id, username, password_hash) VALUES (1, 'testuser', 'testhash'), (2, 'otheruser', 'testhash');
+		INSERT INTO messages (id, title, content, user_id) VALUES (1, 'テストタイトル', 'テスト内容', 1);
+	`)
+	if err != nil {
+		t.Fatalf("テストデータの作成に失敗しました: %v", err)
+	}
+}
+
+func TestPostStore_Create(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewPostStore(db)
+	setupTestThread(t, store)
+
+	// 投稿の作成
+	id, err := store.Create(1, 0, "テスト投稿", 1)
+	if err != nil {
+		t.Errorf("投稿の作成に失敗しました: %v", err)
+	}
+
+	// 返信の作成
+	_, err = store.Create(1, id, "テスト返信", 2)
+	if err != nil {
+		t.Errorf("返信の作成に失敗しました: %v", err)
+	}
+
+	// 存在しない返信先は拒否される
+	_, err = store.Create(1, 999, "不正な返信", 2)
+	if err == nil {
+		t.Error("存在しない返信先を指定した場合にエラーを返すことを期待しますが、そうではありません")
+	}
+
+	// 投稿数の確認
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM posts WHERE message_id = 1").Scan(&count)
+	if err != nil {
+		t.Errorf("投稿数の取得に失敗しました: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("投稿数が2であるべきですが、実際は%dです", count)
+	}
+}
+
+func TestPostStore_List(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewPostStore(db)
+	setupTestThread(t, store)
+
+	_, err := db.Exec(`
+		INSERT INTO messages (id, title, content, user_id) VALUES (2, '別スレッド', '内容', 1);
+		INSERT INTO posts (message_id, content, user_id) VALUES
+			(1, '投稿1', 1),
+			(1, '投稿2', 2),
+			(1, '投稿3', 1),
+			(2, '別スレッドの投稿', 1);
+	`)
+	if err != nil {
+		t.Fatalf("テストデータの作成に失敗しました: %v", err)
+	}
+
+	// 投稿リストの取得
+	posts, total, err := store.List(1, 1, 2)
+	if err != nil {
+		t.Errorf("投稿リストの取得に失敗しました: %v", err)
+	}
+
+	// 結果の確認
+	if total != 3 {
+		t.Errorf("総数が3であるべきですが、実際は%dです", total)
+	}
+	if len(posts) != 2 {
+		t.Fatalf("現在のページの投稿数が2であるべきですが、実際は%dです", len(posts))
+	}
+	if posts[0].Content != "投稿1" {
+		t.Errorf("最初の投稿が'投稿1'であるべきですが、実際は'%s'です", posts[0].Content)
+	}
+	if posts[1].Username != "otheruser" {
+		t.Errorf("投稿者が'otheruser'であるべきですが、実際は'%s'です", posts[1].Username)
+	}
+}
+
+func TestPostStore_Tree(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewPostStore(db)
+	setupTestThread(t, store)
+
+	_, err := db.Exec(`
+		INSERT INTO posts (id, message_id, parent_post_id, content, user_id) VALUES
+			(1, 1, NULL, 'ルート1', 1),
+			(2, 1, NULL, 'ルート2', 2),
+			(3, 1, 1, '返信1-1', 2),
+			(4, 1, 3, '返信1-1-1', 1),
+			(5, 1, 2, '返信2-1', 1);
+	`)
+	if err != nil {
+		t.Fatalf("テストデータの作成に失敗しました: %v", err)
+	}
+
+	// 1ページ目にはルート1とその返信のみが含まれる
+	nodes, total, err := store.Tree(1, 1, 1, 5)
+	if err != nil {
+		t.Fatalf("投稿ツリーの取得に失敗しました: %v", err)
+	}
+	if total != 2 {
+		t.Errorf("トップレベル投稿の総数が2であるべきですが、実際は%dです", total)
+	}
+
+	want := []struct {
+		content string
+		depth   int
+	}{
+		{"ルート1", 0},
+		{"返信1-1", 1},
+		{"返信1-1-1", 2},
+	}
+	if len(nodes) != len(want) {
+		t.Fatalf("ノード数が%dであるべきですが、実際は%dです", len(want), len(nodes))
+	}
+	for i, w := range want {
+		if nodes[i].Content != w.content || nodes[i].Depth != w.depth {
+			t.Errorf("%d番目のノードが(%s, %d)であるべきですが、実際は(%s, %d)です",
+				i, w.content, w.depth, nodes[i].Content, nodes[i].Depth)
+		}
+	}
+}
+
+func TestBuildPostTree(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	posts := []Post{
+		{ID: 4, ParentID: 3, Username: "c", CreatedAt: base.Add(4 * time.Minute)},
+		{ID: 1, Username: "a", CreatedAt: base.Add(1 * time.Minute)},
+		{ID: 5, ParentID: 1, Username: "b", CreatedAt: base.Add(5 * time.Minute)},
+		{ID: 3, ParentID: 2, Username: "b", CreatedAt: base.Add(3 * time.Minute)},
+		{ID: 2, ParentID: 1, Username: "b", CreatedAt: base.Add(2 * time.Minute)},
+		{ID: 6, ParentID: 99, Username: "d", CreatedAt: base.Add(6 * time.Minute)},
+	}
+
+	// 深さ1を超える返信は平坦化される
+	nodes := BuildPostTree(posts, 1)
+
+	want := []struct {
+		id, depth, replies int
+		replyTo            string
+	}{
+		{1, 0, 2, ""},
+		{2, 1, 1, "a"},
+		{3, 1, 1, "b"},
+		{4, 1, 0, "b"},
+		{5, 1, 0, "a"},
+		{6, 0, 0, ""},
+	}
+	if len(nodes) != len(want) {
+		t.Fatalf("ノード数が%dであるべきですが、実際は%dです", len(want), len(nodes))
+	}
+	for i, w := range want {
+		n := nodes[i]
+		if n.ID != w.id || n.Depth != w.depth || n.ReplyCount != w.replies || n.ReplyTo != w.replyTo {
+			t.Errorf("%d番目のノードが(id=%d, depth=%d, replies=%d, reply_to=%q)であるべきですが、実際は(id=%d, depth=%d, replies=%d, reply_to=%q)です",
+				i, w.id, w.depth, w.replies, w.replyTo, n.ID, n.Depth, n.ReplyCount, n.ReplyTo)
+		}
+	}
+}
+
+func TestPostStore_Update(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewPostStore(db)
+	setupTestThread(t, store)
+
+	_, err := db.Exec(`INSERT INTO posts (id, message_id, content, user_id) VALUES (1, 1, '元の投稿', 1)`)
+	if err != nil {
+		t.Fatalf("テストデータの作成に失敗しました: %v", err)
+	}
+
+	// 他のユーザーによる更新は拒否される
+	err = store.Update(1, "不正な更新", 2)
+	if err == nil {
+		t.Error("他のユーザーの投稿を更新した場合にエラーを返すことを期待しますが、そうではありません")
+	}
+
+	// 投稿の更新
+	err = store.Update(1, "新しい投稿", 1)
+	if err != nil {
+		t.Errorf("投稿の更新に失敗しました: %v", err)
+	}
+
+	post, err := store.Get(1)
+	if err != nil {
+		t.Fatalf("更新後の投稿の取得に失敗しました: %v", err)
+	}
+	if post.Content != "新しい投稿" {
+		t.Errorf("内容が'新しい投稿'であるべきですが、実際は'%s'です", post.Content)
+	}
+}
+
+func TestPostStore_Delete(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewPostStore(db)
+	setupTestThread(t, store)
+
+	_, err := db.Exec(`INSERT INTO posts (id, message_id, content, user_id) VALUES (1, 1, 'テスト投稿', 1)`)
+	if err != nil {
+		t.Fatalf("テストデータの作成に失敗しました: %v", err)
+	}
+
+	// 他のユーザーによる削除は拒否される
+	err = store.Delete(1, 2)
+	if err == nil {
+		t.Error("他のユーザーの投稿を削除した場合にエラーを返すことを期待しますが、そうではありません")
+	}
+
+	// 投稿の削除
+	err = store.Delete(1, 1)
+	if err != nil {
+		t.Errorf("投稿の削除に失敗しました: %v", err)
+	}
+
+	// 削除した投稿は内容を空にして残る
+	post, err := store.Get(1)
+	if err != nil {
+		t.Fatalf("削除した投稿の取得に失敗しました: %v", err)
+	}
+	if post.DeletedAt == nil || post.Content != "" {
+		t.Errorf("投稿が削除済みになっていません: %+v", post)
+	}
+
+	// 削除済みの投稿は編集・削除できない
+	if err := store.Delete(1, 1); err == nil || err.Error() != "post not found" {
+		t.Errorf("'post not found'エラーを期待しますが、実際は'%v'です", err)
+	}
+	if err := store.Update(1, "復活", 1); err == nil || err.Error() != "post not found" {
+		t.Errorf("'post not found'エラーを期待しますが、実際は'%v'です", err)
+	}
+}
+
+func TestPostStore_DeleteKeepsReplies(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewPostStore(db)
+	setupTestThread(t, store)
+
+	parentID, err := store.Create(1, 0, "親の投稿", 1)
+	if err != nil {
+		t.Fatalf("投稿の作成に失敗しました: %v", err)
+	}
+	replyID, err := store.Create(1, parentID, "他のユーザーの返信", 2)
+	if err != nil {
+		t.Fatalf("返信の作成に失敗しました: %v", err)
+	}
+
+	// 親の投稿を削除しても、他のユーザーの返信は残る
+	if err := store.Delete(parentID, 1); err != nil {
+		t.Fatalf("投稿の削除に失敗しました: %v", err)
+	}
+	reply, err := store.Get(replyID)
+	if err != nil {
+		t.Fatalf("返信が削除されました: %v", err)
+	}
+	if reply.DeletedAt != nil || reply.Content != "他のユーザーの返信" || reply.ParentID != parentID {
+		t.Errorf("返信が変更されています: %+v", reply)
+	}
+
+	// ツリーでは削除済みの親の下に返信が表示される
+	nodes, _, err := store.Tree(1, 1, 10, 4)
+	if err != nil {
+		t.Fatalf("ツリーの取得に失敗しました: %v", err)
+	}
+	if len(nodes) != 2 || nodes[0].ID != parentID || nodes[0].DeletedAt == nil || nodes[1].ID != replyID || nodes[1].Depth != 1 {
+		t.Errorf("ツリーが正しくありません: %+v", nodes)
+	}
+
+	// 削除済みの投稿には返信できない
+	if _, err := store.Create(1, parentID, "削除済みへの返信", 2); err == nil || err.Error() != "parent post not found" {
+		t.Errorf("'parent post not found'エラーを期待しますが、実際は'%v'です", err)
+	}
+}
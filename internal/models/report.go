@@ -0,0 +1,298 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ReportCategory は通報の理由の分類
+type ReportCategory string
+
+const (
+	ReportSpam          ReportCategory = "spam"
+	ReportHarassment    ReportCategory = "harassment"
+	ReportInappropriate ReportCategory = "inappropriate"
+	ReportOther         ReportCategory = "other"
+)
+
+// AllReportCategories は通報フォームに表示する順に並べたすべての分類
+var AllReportCategories = []ReportCategory{ReportSpam, ReportHarassment, ReportInappropriate, ReportOther}
+
+var reportCategoryLabels = map[ReportCategory]string{
+	ReportSpam:          "スパム・宣伝",
+	ReportHarassment:    "嫌がらせ・誹謗中傷",
+	ReportInappropriate: "不適切な内容",
+	ReportOther:         "その他",
+}
+
+// Label は画面に表示する分類の名前を返す
+func (c ReportCategory) Label() string {
+	return reportCategoryLabels[c]
+}
+
+// ParseReportCategory は文字列を通報の分類に変換する。不明な分類の場合は false を返す。
+func ParseReportCategory(s string) (ReportCategory, bool) {
+	for _, c := range AllReportCategories {
+		if string(c) == s {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// ReportResolution はモデレーターによる通報への対応
+type ReportResolution string
+
+const (
+	// ResolutionDismiss は問題なしとして通報を却下する
+	ResolutionDismiss ReportResolution = "dismiss"
+	// ResolutionHide はメッセージを一覧や検索結果から非表示にする
+	ResolutionHide ReportResolution = "hide"
+	// ResolutionDelete はメッセージを削除する
+	ResolutionDelete ReportResolution = "delete"
+	// ResolutionSuspend は投稿者の利用を停止し、メッセージを非表示にする
+	ResolutionSuspend ReportResolution = "suspend"
+)
+
+// ParseReportResolution は文字列を通報への対応に変換する。不明な対応の場合は false を返す。
+func ParseReportResolution(s string) (ReportResolution, bool) {
+	switch r := ReportResolution(s); r {
+	case ResolutionDismiss, ResolutionHide, ResolutionDelete, ResolutionSuspend:
+		return r, true
+	}
+	return "", false
+}
+
+// Report はメッセージに対する1件の通報
+type Report struct {
+	ID         int            `json:"id"`
+	MessageID  int            `json:"message_id"`
+	ReporterID int            `json:"reporter_id"`
+	Reporter   string         `json:"reporter"`
+	Category   ReportCategory `json:"category"`
+	Note       string         `json:"note"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// ReportCount は通報の分類ごとの件数
+type ReportCount struct {
+	Category ReportCategory `json:"category"`
+	Count    int            `json:"count"`
+}
+
+// ReportGroup はモデレーションキューに表示する、1件のメッセージに対する未対応の通報のまとまり
+type ReportGroup struct {
+	MessageID      int           `json:"message_id"`
+	Title          string        `json:"title"`
+	Content        string        `json:"content"`
+	AuthorID       int           `json:"author_id"`
+	Author         string        `json:"author"`
+	HiddenAt       *time.Time    `json:"hidden_at,omitempty"`
+	Count          int           `json:"count"`
+	Counts         []ReportCount `json:"counts"`
+	Reports        []Report      `json:"reports"`
+	LastReportedAt time.Time     `json:"last_reported_at"`
+}
+
+type ReportStore struct {
+	db *sql.DB
+}
+
+func NewReportStore(db *sql.DB) *ReportStore {
+	return &ReportStore{db: db}
+}
+
+// Create はメッセージへの通報を記録する。
+// 同じユーザーは対応が済むまで同じメッセージを重ねて通報できない。
+func (s *ReportStore) Create(messageID, reporterID int, category ReportCategory, note string) error {
+	if _, ok := ParseReportCategory(string(category)); !ok {
+		return validationError("invalid report category")
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO reports (message_id, reporter_id, category, note)
+		SELECT id, $2, $3, $4 FROM messages WHERE id = $1 AND deleted_at IS NULL`, messageID, reporterID, category, note)
+	if isUniqueViolation(err, "reports_open_idx") {
+		return conflictError("already reported")
+	}
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return notFoundError("message not found")
+	}
+	return nil
+}
+
+// Queue は未対応の通報をメッセージごとにまとめ、通報の多い順に1ページ分とメッセージの総数を返す
+func (s *ReportStore) Queue(page, perPage int) ([]ReportGroup, int, error) {
+	var total int
+	err := s.db.QueryRow(`
+		SELECT COUNT(DISTINCT r.message_id)
+		FROM reports r
+		JOIN messages m ON r.message_id = m.id
+		WHERE r.resolved_at IS NULL AND m.deleted_at IS NULL`).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * perPage
+	rows, err := s.db.Query(`
+		SELECT m.id, m.title, m.content, COALESCE(m.user_id, 0), COALESCE(u.username, ''), m.hidden_at,
+			COUNT(*), MAX(r.created_at)
+		FROM reports r
+		JOIN messages m ON r.message_id = m.id
+		LEFT JOIN users u ON m.user_id = u.id
+		WHERE r.resolved_at IS NULL AND m.deleted_at IS NULL
+		GROUP BY m.id, u.username
+		ORDER BY COUNT(*) DESC, MIN(r.created_at)
+		LIMIT $1 OFFSET $2`, perPage, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var groups []ReportGroup
+	var ids []int64
+	index := map[int]int{}
+	for rows.Next() {
+		var g ReportGroup
+		err := rows.Scan(&g.MessageID, &g.Title, &g.Content, &g.AuthorID, &g.Author, &g.HiddenAt,
+			&g.Count, &g.LastReportedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		index[g.MessageID] = len(groups)
+		ids = append(ids, int64(g.MessageID))
+		groups = append(groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(groups) == 0 {
+		return groups, total, nil
+	}
+
+	// ページに含まれるメッセージの通報を取得して振り分ける
+	reports, err := s.db.Query(`
+		SELECT r.id, r.message_id, COALESCE(r.reporter_id, 0), COALESCE(u.username, ''), r.category, r.note, r.created_at
+		FROM reports r
+		LEFT JOIN users u ON r.reporter_id = u.id
+		WHERE r.resolved_at IS NULL AND r.message_id = ANY($1)
+		ORDER BY r.created_at`, pq.Array(ids))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer reports.Close()
+
+	for reports.Next() {
+		var r Report
+		err := reports.Scan(&r.ID, &r.MessageID, &r.ReporterID, &r.Reporter, &r.Category, &r.Note, &r.CreatedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		g := &groups[index[r.MessageID]]
+		g.Reports = append(g.Reports, r)
+	}
+	if err := reports.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	for i := range groups {
+		groups[i].Counts = countByCategory(groups[i].Reports)
+	}
+	return groups, total, nil
+}
+
+// countByCategory は通報を分類ごとに数え、1件以上ある分類を AllReportCategories の順に返す
+func countByCategory(reports []Report) []ReportCount {
+	counts := map[ReportCategory]int{}
+	for _, r := range reports {
+		counts[r.Category]++
+	}
+	var result []ReportCount
+	for _, c := range AllReportCategories {
+		if counts[c] > 0 {
+			result = append(result, ReportCount{Category: c, Count: counts[c]})
+		}
+	}
+	return result
+}
+
+// Resolve はメッセージに対する未対応の通報をすべて対応済みにし、対応に応じてメッセージを非表示にするか削除する。
+// 投稿者の利用停止（ResolutionSuspend）では、until と reason で投稿者の利用も停止する。
+// 未対応の通報がない場合は何も変更しない。対応はモデレーションログに記録する。
+func (s *ReportStore) Resolve(actorID, messageID int, resolution ReportResolution, until *time.Time, reason string) error {
+	if _, ok := ParseReportResolution(string(resolution)); !ok {
+		return validationError("invalid report resolution")
+	}
+	if resolution == ResolutionSuspend && reason == "" {
+		return validationError("suspension reason is required")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var ownerID sql.NullInt64
+	var title string
+	err = tx.QueryRow(`SELECT user_id, title FROM messages WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, messageID).Scan(&ownerID, &title)
+	if err == sql.ErrNoRows {
+		return notFoundError("message not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`
+		UPDATE reports
+		SET resolution = $2, resolved_by = $3, resolved_at = CURRENT_TIMESTAMP
+		WHERE message_id = $1 AND resolved_at IS NULL`, messageID, resolution, actorID)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return notFoundError("report not found")
+	}
+
+	if resolution == ResolutionSuspend {
+		if !ownerID.Valid {
+			return notFoundError("user not found")
+		}
+		if err := suspendUser(tx, actorID, int(ownerID.Int64), until, reason); err != nil {
+			return err
+		}
+	}
+
+	switch resolution {
+	case ResolutionHide, ResolutionSuspend:
+		_, err = tx.Exec(`UPDATE messages SET hidden_at = COALESCE(hidden_at, CURRENT_TIMESTAMP) WHERE id = $1`, messageID)
+	case ResolutionDelete:
+		// モデレーターによる削除のため、投稿者のゴミ箱には表示されない
+		_, err = tx.Exec(`
+			UPDATE messages SET deleted_at = CURRENT_TIMESTAMP, deleted_by = $2
+			WHERE id = $1`, messageID, actorID)
+	}
+	if err != nil {
+		return err
+	}
+
+	details := fmt.Sprintf("%s (%d件)", title, count)
+	if err := logModeration(tx, actorID, "report."+string(resolution), messageID, int(ownerID.Int64), details); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
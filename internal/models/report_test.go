@@ -0,0 +1,213 @@
+package models
+
+import "testing"
+
+func TestParseReportCategory(t *testing.T) {
+	if c, ok := ParseReportCategory("spam"); !ok || c != ReportSpam {
+		t.Errorf("'spam'がスパムとして解釈されません: %v, %v", c, ok)
+	}
+	if _, ok := ParseReportCategory("unknown"); ok {
+		t.Error("不明な分類が受け付けられました")
+	}
+	for _, c := range AllReportCategories {
+		if c.Label() == "" {
+			t.Errorf("分類'%s'の表示名がありません", c)
+		}
+	}
+}
+
+func TestReportStore_Queue(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewReportStore(db)
+
+	_, err := db.Exec(`
+		INSERT INTO users (id, username, password_hash) VALUES (1, 'owner', 'testhash');
+		INSERT INTO users (id, username, password_hash) VALUES (2, 'alice', 'testhash');
+		INSERT INTO users (id, username, password_hash) VALUES (3, 'bob', 'testhash');
+		INSERT INTO messages (id, title, content, user_id) VALUES (1, 'スパム', '宣伝です', 1);
+		INSERT INTO messages (id, title, content, user_id) VALUES (2, '普通の投稿', 'こんにちは', 1);
+	`)
+	if err != nil {
+		t.Fatalf("テストデータの作成に失敗しました: %v", err)
+	}
+
+	if err := store.Create(1, 2, ReportSpam, "同じ宣伝が何度も"); err != nil {
+		t.Fatalf("通報に失敗しました: %v", err)
+	}
+	if err := store.Create(1, 3, ReportSpam, ""); err != nil {
+		t.Fatalf("通報に失敗しました: %v", err)
+	}
+	if err := store.Create(2, 2, ReportOther, ""); err != nil {
+		t.Fatalf("通報に失敗しました: %v", err)
+	}
+
+	// 同じユーザーは未対応の通報を重ねて作れない
+	err = store.Create(1, 2, ReportHarassment, "")
+	if err == nil || err.Error() != "already reported" {
+		t.Errorf("'already reported'エラーを期待しますが、実際は'%v'です", err)
+	}
+	err = store.Create(999, 2, ReportSpam, "")
+	if err == nil || err.Error() != "message not found" {
+		t.Errorf("'message not found'エラーを期待しますが、実際は'%v'です", err)
+	}
+
+	// 通報の多いメッセージから順に並ぶ
+	groups, total, err := store.Queue(1, 10)
+	if err != nil {
+		t.Fatalf("モデレーションキューの取得に失敗しました: %v", err)
+	}
+	if total != 2 || len(groups) != 2 {
+		t.Fatalf("2件のメッセージを期待しますが、実際は%d件（総数%d）です", len(groups), total)
+	}
+	if groups[0].MessageID != 1 || groups[0].Count != 2 {
+		t.Errorf("最初のメッセージが通報2件のメッセージ1であるべきですが、実際は%+v です", groups[0])
+	}
+	if len(groups[0].Counts) != 1 || groups[0].Counts[0].Category != ReportSpam || groups[0].Counts[0].Count != 2 {
+		t.Errorf("分類ごとの件数が正しくありません: %+v", groups[0].Counts)
+	}
+	if len(groups[0].Reports) != 2 || groups[0].Reports[0].Reporter != "alice" {
+		t.Errorf("通報の一覧が正しくありません: %+v", groups[0].Reports)
+	}
+}
+
+func TestReportStore_Resolve(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewReportStore(db)
+	messageStore := NewMessageStore(db)
+
+	_, err := db.Exec(`
+		INSERT INTO users (id, username, password_hash) VALUES (1, 'owner', 'testhash');
+		INSERT INTO users (id, username, password_hash) VALUES (2, 'alice', 'testhash');
+		INSERT INTO users (id, username, password_hash, role) VALUES (3, 'moderator', 'testhash', 'moderator');
+		INSERT INTO messages (id, title, content, user_id) VALUES (1, '非表示にする', '内容', 1);
+		INSERT INTO messages (id, title, content, user_id) VALUES (2, '削除する', '内容', 1);
+		INSERT INTO messages (id, title, content, user_id) VALUES (3, '問題なし', '内容', 1);
+		INSERT INTO reports (message_id, reporter_id, category) VALUES (1, 2, 'spam'), (2, 2, 'harassment'), (3, 2, 'other');
+	`)
+	if err != nil {
+		t.Fatalf("テストデータの作成に失敗しました: %v", err)
+	}
+
+	// 非表示にしたメッセージは一覧と検索から除かれるが、個別には取得できる
+	if err := store.Resolve(3, 1, ResolutionHide, nil, ""); err != nil {
+		t.Fatalf("非表示にできません: %v", err)
+	}
+	messages, total, err := messageStore.List(1, 10)
+	if err != nil {
+		t.Fatalf("メッセージ一覧の取得に失敗しました: %v", err)
+	}
+	if total != 2 {
+		t.Errorf("一覧の総数が2件であるべきですが、実際は%d件です", total)
+	}
+	for _, m := range messages {
+		if m.ID == 1 {
+			t.Error("非表示のメッセージが一覧に含まれています")
+		}
+	}
+	if _, total, _ := messageStore.Search(SearchOptions{Query: "非表示", Mode: SearchModeTrigram}, 1, 10); total != 0 {
+		t.Errorf("非表示のメッセージが検索結果に含まれています: %d件", total)
+	}
+	msg, err := messageStore.Get(1)
+	if err != nil {
+		t.Fatalf("メッセージの取得に失敗しました: %v", err)
+	}
+	if msg.HiddenAt == nil {
+		t.Error("非表示の日時が設定されていません")
+	}
+
+	// 削除したメッセージは取得できない
+	if err := store.Resolve(3, 2, ResolutionDelete, nil, ""); err != nil {
+		t.Fatalf("削除できません: %v", err)
+	}
+	if _, err := messageStore.Get(2); err == nil || err.Error() != "message not found" {
+		t.Errorf("'message not found'エラーを期待しますが、実際は'%v'です", err)
+	}
+
+	// 却下してもメッセージは残る
+	if err := store.Resolve(3, 3, ResolutionDismiss, nil, ""); err != nil {
+		t.Fatalf("却下できません: %v", err)
+	}
+	if msg, err := messageStore.Get(3); err != nil || msg.HiddenAt != nil {
+		t.Errorf("却下したメッセージが変更されています: %v", err)
+	}
+
+	// 対応済みのメッセージはキューに残らず、再度対応することもできない
+	groups, total, err := store.Queue(1, 10)
+	if err != nil {
+		t.Fatalf("モデレーションキューの取得に失敗しました: %v", err)
+	}
+	if total != 0 || len(groups) != 0 {
+		t.Errorf("キューが空であるべきですが、実際は%d件です", total)
+	}
+	err = store.Resolve(3, 3, ResolutionDismiss, nil, "")
+	if err == nil || err.Error() != "report not found" {
+		t.Errorf("'report not found'エラーを期待しますが、実際は'%v'です", err)
+	}
+
+	// 再表示を含め、すべての対応がモデレーションログに残る
+	if err := messageStore.Unhide(1, 3); err != nil {
+		t.Fatalf("再表示できません: %v", err)
+	}
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM moderation_log WHERE actor_id = 3 AND target_user_id = 1`).Scan(&count)
+	if err != nil {
+		t.Fatalf("モデレーションログの取得に失敗しました: %v", err)
+	}
+	if count != 4 {
+		t.Errorf("モデレーションログが4件であるべきですが、実際は%d件です", count)
+	}
+}
+
+func TestReportStore_ResolveSuspend(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewReportStore(db)
+	userStore := NewUserStore(db)
+
+	_, err := db.Exec(`
+		INSERT INTO users (id, username, password_hash) VALUES (1, 'owner', 'testhash');
+		INSERT INTO users (id, username, password_hash) VALUES (2, 'alice', 'testhash');
+		INSERT INTO users (id, username, password_hash, role) VALUES (3, 'moderator', 'testhash', 'moderator');
+		INSERT INTO messages (id, title, content, user_id) VALUES (1, 'スパム', '宣伝です', 1);
+		INSERT INTO reports (message_id, reporter_id, category) VALUES (1, 2, 'spam');
+	`)
+	if err != nil {
+		t.Fatalf("テストデータの作成に失敗しました: %v", err)
+	}
+
+	if err := store.Resolve(3, 1, ResolutionSuspend, nil, "宣伝の繰り返し"); err != nil {
+		t.Fatalf("投稿者の利用を停止できません: %v", err)
+	}
+	user, err := userStore.GetByID(1)
+	if err != nil {
+		t.Fatalf("ユーザーの取得に失敗しました: %v", err)
+	}
+	if !user.Suspended() || user.Suspension.Reason != "宣伝の繰り返し" {
+		t.Errorf("投稿者が利用停止されていません: %+v", user.Suspension)
+	}
+
+	// 利用停止を解除してから同じ対応を繰り返しても、通報がないため利用停止もされない
+	if err := userStore.Unsuspend(3, 1); err != nil {
+		t.Fatalf("利用停止を解除できません: %v", err)
+	}
+	err = store.Resolve(3, 1, ResolutionSuspend, nil, "宣伝の繰り返し")
+	if err == nil || err.Error() != "report not found" {
+		t.Errorf("'report not found'エラーを期待しますが、実際は'%v'です", err)
+	}
+	if user, _ := userStore.GetByID(1); user.Suspended() {
+		t.Error("対応に失敗したのに投稿者が利用停止されています")
+	}
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM moderation_log WHERE action = $1`, ModerationSuspendUser).Scan(&count)
+	if err != nil {
+		t.Fatalf("モデレーションログの取得に失敗しました: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("利用停止の記録が1件であるべきですが、実際は%d件です", count)
+	}
+}
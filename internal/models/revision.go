@@ -0,0 +1,56 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Revision はメッセージのある時点の版。版番号は Message.Version と同じく1（最初の投稿）から始まる。
+type Revision struct {
+	Number    int       `json:"revision"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	EditedBy  string    `json:"edited_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// saveRevision は更新で置き換えられる前のメッセージの版を編集履歴に保存する。
+// メッセージの行をロックした Update のトランザクション内で呼び出す。
+func saveRevision(tx *sql.Tx, messageID int) error {
+	_, err := tx.Exec(`
+		INSERT INTO message_revisions (message_id, revision, title, content, edited_by, created_at)
+		SELECT m.id, m.version, m.title, m.content, COALESCE(m.updated_by, m.user_id), m.updated_at
+		FROM messages m
+		WHERE m.id = $1`, messageID)
+	return err
+}
+
+// History はメッセージの編集履歴を古い順に返す。最後の要素は現在の版。
+// 各版の日時はその版が投稿または編集された日時。
+func (s *MessageStore) History(messageID int) ([]Revision, error) {
+	rows, err := s.db.Query(`
+		SELECT r.revision, r.title, r.content, COALESCE(u.username, ''), r.created_at
+		FROM message_revisions r
+		LEFT JOIN users u ON r.edited_by = u.id
+		WHERE r.message_id = $1
+		UNION ALL
+		SELECT m.version, m.title, m.content, COALESCE(u.username, ''), m.updated_at
+		FROM messages m
+		LEFT JOIN users u ON COALESCE(m.updated_by, m.user_id) = u.id
+		WHERE m.id = $1 AND m.deleted_at IS NULL
+		ORDER BY 1`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []Revision
+	for rows.Next() {
+		var r Revision
+		if err := rows.Scan(&r.Number, &r.Title, &r.Content, &r.EditedBy, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
@@ -0,0 +1,69 @@
+package models
+
+import "testing"
+
+func TestMessageStore_History(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewMessageStore(db)
+
+	_, err := db.Exec(`
+		INSERT INTO users (id, username, password_hash) VALUES (1, 'owner', 'testhash');
+		INSERT INTO users (id, username, password_hash, role) VALUES (2, 'moderator', 'testhash', 'moderator');
+		INSERT INTO messages (id, title, content, user_id) VALUES (1, '最初のタイトル', '最初の内容', 1);
+	`)
+	if err != nil {
+		t.Fatalf("テストデータの作成に失敗しました: %v", err)
+	}
+
+	// 編集前は現在の版のみ
+	revisions, err := store.History(1)
+	if err != nil {
+		t.Fatalf("編集履歴の取得に失敗しました: %v", err)
+	}
+	if len(revisions) != 1 || revisions[0].Number != 1 || revisions[0].EditedBy != "owner" {
+		t.Fatalf("現在の版のみであるべきですが、実際は%+vです", revisions)
+	}
+
+	// 投稿者とモデレーターが編集すると、編集前の版が残る
+	if err := store.Update(1, "二番目", "二番目の内容", 1, 1); err != nil {
+		t.Fatalf("メッセージの更新に失敗しました: %v", err)
+	}
+	if err := store.Update(1, "三番目", "三番目の内容", 2, 2); err != nil {
+		t.Fatalf("メッセージの更新に失敗しました: %v", err)
+	}
+
+	revisions, err = store.History(1)
+	if err != nil {
+		t.Fatalf("編集履歴の取得に失敗しました: %v", err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("3つの版があるべきですが、実際は%d個です", len(revisions))
+	}
+	want := []struct {
+		title, editedBy string
+	}{
+		{"最初のタイトル", "owner"},
+		{"二番目", "owner"},
+		{"三番目", "moderator"},
+	}
+	for i, w := range want {
+		r := revisions[i]
+		if r.Number != i+1 || r.Title != w.title || r.EditedBy != w.editedBy {
+			t.Errorf("第%d版が正しくありません: %+v", i+1, r)
+		}
+	}
+
+	// 権限のない更新では版が増えない
+	if err := store.Update(1, "不正", "不正", 999, 3); err == nil {
+		t.Fatal("権限のないユーザーが更新できました")
+	}
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM message_revisions WHERE message_id = 1`).Scan(&count); err != nil {
+		t.Fatalf("編集履歴の件数の取得に失敗しました: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("保存された版が2件であるべきですが、実際は%d件です", count)
+	}
+}
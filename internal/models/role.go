@@ -0,0 +1,65 @@
+package models
+
+// Role はユーザーの役割。役割ごとに許可される操作（Permission）が決まる。
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// AllRoles は権限の弱い順に並べたすべての役割
+var AllRoles = []Role{RoleUser, RoleModerator, RoleAdmin}
+
+// Permission は役割に与えられる操作の権限
+type Permission string
+
+const (
+	// PermissionModerateContent は他のユーザーのスレッドや投稿を編集・削除する権限
+	PermissionModerateContent Permission = "content:moderate"
+	// PermissionManageUsers はユーザーの役割の変更や利用停止を行う権限
+	PermissionManageUsers Permission = "users:manage"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleModerator: {PermissionModerateContent},
+	RoleAdmin:     {PermissionModerateContent, PermissionManageUsers},
+}
+
+var roleLabels = map[Role]string{
+	RoleUser:      "一般ユーザー",
+	RoleModerator: "モデレーター",
+	RoleAdmin:     "管理者",
+}
+
+// Can は役割が指定された権限を持っているかを返す
+func (r Role) Can(p Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Label は画面に表示する役割の名前を返す
+func (r Role) Label() string {
+	return roleLabels[r]
+}
+
+// ParseRole は文字列を役割に変換する。不明な役割の場合は false を返す。
+func ParseRole(s string) (Role, bool) {
+	for _, r := range AllRoles {
+		if string(r) == s {
+			return r, true
+		}
+	}
+	return "", false
+}
+
+// CanModify は actorID のユーザー（役割 actorRole）が ownerID のユーザーのコンテンツを
+// 編集・削除できるかを返す。自分のコンテンツか、モデレーションの権限がある場合に許可する。
+func CanModify(actorID int, actorRole Role, ownerID int) bool {
+	return actorID == ownerID || actorRole.Can(PermissionModerateContent)
+}
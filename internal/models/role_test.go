@@ -0,0 +1,46 @@
+package models
+
+import "testing"
+
+func TestRole_Can(t *testing.T) {
+	tests := []struct {
+		role       Role
+		permission Permission
+		want       bool
+	}{
+		{RoleUser, PermissionModerateContent, false},
+		{RoleUser, PermissionManageUsers, false},
+		{RoleModerator, PermissionModerateContent, true},
+		{RoleModerator, PermissionManageUsers, false},
+		{RoleAdmin, PermissionModerateContent, true},
+		{RoleAdmin, PermissionManageUsers, true},
+		{Role("unknown"), PermissionModerateContent, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.role.Can(tt.permission); got != tt.want {
+			t.Errorf("%s.Can(%s) が%vであるべきですが、実際は%vです", tt.role, tt.permission, tt.want, got)
+		}
+	}
+}
+
+func TestCanModify(t *testing.T) {
+	if !CanModify(1, RoleUser, 1) {
+		t.Error("自分のコンテンツを編集できません")
+	}
+	if CanModify(1, RoleUser, 2) {
+		t.Error("一般ユーザーが他のユーザーのコンテンツを編集できてしまいました")
+	}
+	if !CanModify(1, RoleModerator, 2) {
+		t.Error("モデレーターが他のユーザーのコンテンツを編集できません")
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	if r, ok := ParseRole("moderator"); !ok || r != RoleModerator {
+		t.Errorf("'moderator'をモデレーターとして解釈できません: %v, %v", r, ok)
+	}
+	if _, ok := ParseRole("root"); ok {
+		t.Error("不明な役割を受け付けてしまいました")
+	}
+}
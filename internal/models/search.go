@@ -0,0 +1,170 @@
+package models
+
+import (
+	"html"
+	"strings"
+	"time"
+)
+
+// SearchMode は検索方式を表す
+type SearchMode string
+
+const (
+	// SearchModeFullText は tsvector による単語単位の全文検索
+	SearchModeFullText SearchMode = "fulltext"
+	// SearchModeTrigram は pg_trgm による部分一致検索（日本語向け）
+	SearchModeTrigram SearchMode = "trigram"
+)
+
+// SearchSort は検索結果の並び順を表す
+type SearchSort string
+
+const (
+	// SearchSortRelevance は関連度順（検索語がない場合は新しい順）
+	SearchSortRelevance SearchSort = "relevance"
+	SearchSortNewest    SearchSort = "newest"
+	SearchSortOldest    SearchSort = "oldest"
+	// SearchSortUpdated は更新日時の新しい順
+	SearchSortUpdated SearchSort = "updated"
+)
+
+// SearchDateLayout は日付による絞り込みで使う書式
+const SearchDateLayout = "2006-01-02"
+
+// SearchOptions は検索語と絞り込み条件をまとめたもの。
+// From/To はゼロ値なら条件なしで、To はその日の終わりまでを含む。
+type SearchOptions struct {
+	Query      string
+	Mode       SearchMode
+	Author     string
+	From       time.Time
+	To         time.Time
+	EditedOnly bool
+	Sort       SearchSort
+}
+
+// IsEmpty は検索語も絞り込み条件も指定されていない場合に true を返す
+func (o SearchOptions) IsEmpty() bool {
+	return o.Query == "" && o.Author == "" && o.From.IsZero() && o.To.IsZero() && !o.EditedOnly
+}
+
+// ParseSearchQuery は検索欄の文字列からインライン演算子
+// （author:ユーザー名、from:/to:日付、edited:true、sort:並び順）を取り出し、残りを検索語とする。
+// 引用符で囲まれたフレーズ内の演算子は検索語として扱う。
+func ParseSearchQuery(raw string) (SearchOptions, error) {
+	var opts SearchOptions
+	var terms []string
+	inQuote := false
+	for _, field := range strings.Fields(raw) {
+		if !inQuote {
+			if key, value, ok := strings.Cut(field, ":"); ok && value != "" {
+				handled, err := opts.Set(key, value)
+				if err != nil {
+					return opts, err
+				}
+				if handled {
+					continue
+				}
+			}
+		}
+		if strings.Count(field, `"`)%2 == 1 {
+			inQuote = !inQuote
+		}
+		terms = append(terms, field)
+	}
+	opts.Query = strings.Join(terms, " ")
+	return opts, nil
+}
+
+// Set は名前で指定された絞り込み条件を設定する。
+// 未知の名前の場合は false を返し、値が不正な場合はエラーを返す。
+func (o *SearchOptions) Set(key, value string) (bool, error) {
+	switch strings.ToLower(key) {
+	case "author":
+		o.Author = value
+	case "from", "to":
+		t, err := time.ParseInLocation(SearchDateLayout, value, time.Local)
+		if err != nil {
+			return true, validationError("invalid date: " + value)
+		}
+		if strings.ToLower(key) == "from" {
+			o.From = t
+		} else {
+			o.To = t
+		}
+	case "edited":
+		switch strings.ToLower(value) {
+		case "true", "on", "1":
+			o.EditedOnly = true
+		case "false", "off", "0":
+			o.EditedOnly = false
+		default:
+			return true, validationError("invalid edited value: " + value)
+		}
+	case "sort":
+		switch sort := SearchSort(strings.ToLower(value)); sort {
+		case SearchSortRelevance, SearchSortNewest, SearchSortOldest, SearchSortUpdated:
+			o.Sort = sort
+		default:
+			return true, validationError("invalid sort order: " + value)
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// トライグラムインデックスが使える最小のクエリ長（文字数）
+const trigramMinLength = 3
+
+// ParseSearchMode は文字列を検索方式に変換する。不明な値は全文検索として扱う。
+func ParseSearchMode(s string) SearchMode {
+	if SearchMode(s) == SearchModeTrigram {
+		return SearchModeTrigram
+	}
+	return SearchModeFullText
+}
+
+// ts_headline が出力するハイライト位置の目印。
+// 本文に現れることのない私用領域の文字を使い、エスケープ後に<mark>タグへ置き換える。
+const (
+	markStart = "\ue000"
+	markStop  = "\ue001"
+)
+
+var headlineOptions = `StartSel="` + markStart + `", StopSel="` + markStop + `", MaxWords=20, MinWords=5, MaxFragments=2`
+
+// highlightSnippet は目印付きのスニペットをHTMLエスケープし、目印を<mark>タグに置き換える
+func highlightSnippet(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, markStart, "<mark>")
+	return strings.ReplaceAll(escaped, markStop, "</mark>")
+}
+
+// markSubstring はテキスト中のクエリに一致する箇所（大文字小文字を区別しない）を目印で囲む
+func markSubstring(text, query string) string {
+	q := []rune(query)
+	if len(q) == 0 {
+		return text
+	}
+
+	r := []rune(text)
+	var b strings.Builder
+	for i := 0; i < len(r); {
+		if i+len(q) <= len(r) && strings.EqualFold(string(r[i:i+len(q)]), query) {
+			b.WriteString(markStart)
+			b.WriteString(string(r[i : i+len(q)]))
+			b.WriteString(markStop)
+			i += len(q)
+			continue
+		}
+		b.WriteRune(r[i])
+		i++
+	}
+	return b.String()
+}
+
+// escapeLike は LIKE パターンの特殊文字をエスケープする
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHighlightSnippet(t *testing.T) {
+	snippet := "<b>太字</b> と " + markStart + "検索語" + markStop + " & その他"
+
+	got := highlightSnippet(snippet)
+	want := "&lt;b&gt;太字&lt;/b&gt; と <mark>検索語</mark> &amp; その他"
+	if got != want {
+		t.Errorf("ハイライト結果が'%s'であるべきですが、実際は'%s'です", want, got)
+	}
+}
+
+func TestMarkSubstring(t *testing.T) {
+	tests := []struct {
+		text, query, want string
+	}{
+		{"東京は晴れです", "晴れ", "東京は" + markStart + "晴れ" + markStop + "です"},
+		{"Go and GO", "go", markStart + "Go" + markStop + " and " + markStart + "GO" + markStop},
+		{"一致なし", "abc", "一致なし"},
+		{"空のクエリ", "", "空のクエリ"},
+	}
+
+	for _, tt := range tests {
+		if got := markSubstring(tt.text, tt.query); got != tt.want {
+			t.Errorf("markSubstring(%q, %q) が%qであるべきですが、実際は%qです", tt.text, tt.query, tt.want, got)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	got := escapeLike(`100%_\`)
+	want := `100\%\_\\`
+	if got != want {
+		t.Errorf("エスケープ結果が%qであるべきですが、実際は%qです", want, got)
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	opts, err := ParseSearchQuery(`"author:そのまま" 週報 author:alice from:2024-01-01 to:2024-01-31 edited:true sort:oldest -除外 url:http://example.com`)
+	if err != nil {
+		t.Fatalf("検索クエリの解析に失敗しました: %v", err)
+	}
+
+	if opts.Query != `"author:そのまま" 週報 -除外 url:http://example.com` {
+		t.Errorf("検索語が正しくありません: '%s'", opts.Query)
+	}
+	if opts.Author != "alice" {
+		t.Errorf("投稿者が'alice'であるべきですが、実際は'%s'です", opts.Author)
+	}
+	if !opts.From.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("開始日が正しくありません: %v", opts.From)
+	}
+	if !opts.To.Equal(time.Date(2024, 1, 31, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("終了日が正しくありません: %v", opts.To)
+	}
+	if !opts.EditedOnly {
+		t.Error("編集済みのみの条件が設定されていません")
+	}
+	if opts.Sort != SearchSortOldest {
+		t.Errorf("並び順が'oldest'であるべきですが、実際は'%s'です", opts.Sort)
+	}
+
+	// 不正な値はエラーになる
+	for _, q := range []string{"from:2024/01/01", "edited:maybe", "sort:random"} {
+		if _, err := ParseSearchQuery(q); err == nil {
+			t.Errorf("'%s'の解析でエラーを返すことを期待しますが、そうではありません", q)
+		}
+	}
+}
+
+func TestSearchOptions_IsEmpty(t *testing.T) {
+	if !(SearchOptions{Sort: SearchSortNewest, Mode: SearchModeTrigram}).IsEmpty() {
+		t.Error("並び順と検索方式のみの条件は空であるべきです")
+	}
+	if (SearchOptions{Author: "alice"}).IsEmpty() {
+		t.Error("投稿者を指定した条件は空であるべきではありません")
+	}
+}
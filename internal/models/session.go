@@ -0,0 +1,268 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// 直前のリフレッシュトークンを再提示しても不正使用とみなさない猶予期間。
+// 同時に送られた複数のリクエストが同じトークンで更新しようとした場合に備える。
+const refreshGracePeriod = 30 * time.Second
+
+// Session はログインごとに作られるサーバー側のセッション。
+// アクセストークンはセッションIDを持ち、失効したセッションのトークンは拒否される。
+type Session struct {
+	ID       string `json:"id"`
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Role     Role   `json:"-"`
+	// Suspension はユーザーが利用停止中の場合の内容。Get と List でのみ設定される。
+	Suspension *Suspension `json:"-"`
+	UserAgent  string      `json:"user_agent"`
+	IPAddress  string      `json:"ip_address"`
+	CreatedAt  time.Time   `json:"created_at"`
+	LastSeenAt time.Time   `json:"last_seen_at"`
+	ExpiresAt  time.Time   `json:"expires_at"`
+	RevokedAt  *time.Time  `json:"revoked_at"`
+}
+
+// 最終アクセス日時を更新する間隔。リクエストごとの書き込みを避ける。
+const sessionTouchInterval = time.Minute
+
+type SessionStore struct {
+	db *sql.DB
+}
+
+func NewSessionStore(db *sql.DB) *SessionStore {
+	return &SessionStore{db: db}
+}
+
+// Create は新しいセッションを作成し、セッションとリフレッシュトークンを返す。
+// userAgent と ipAddress はアカウントページで端末を見分けるために記録する。
+func (s *SessionStore) Create(userID int, userAgent, ipAddress string, lifetime time.Duration) (*Session, string, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	session := Session{ID: id, UserID: userID, UserAgent: userAgent, IPAddress: ipAddress}
+	err = s.db.QueryRow(`
+		INSERT INTO sessions (id, user_id, refresh_token_hash, user_agent, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING (SELECT username FROM users WHERE id = $2), (SELECT role FROM users WHERE id = $2),
+			created_at, last_seen_at, expires_at`,
+		id, userID, hashToken(secret), userAgent, ipAddress, time.Now().Add(lifetime)).
+		Scan(&session.Username, &session.Role, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+	return &session, id + "." + secret, nil
+}
+
+// Get は有効な（失効・期限切れでない）セッションを返す
+func (s *SessionStore) Get(id string) (*Session, error) {
+	session, err := scanSession(s.db.QueryRow(`
+		SELECT `+sessionColumns+`
+		FROM sessions s
+		JOIN users u ON s.user_id = u.id
+		WHERE s.id = $1 AND s.revoked_at IS NULL AND s.expires_at > CURRENT_TIMESTAMP`, id))
+	if err == sql.ErrNoRows {
+		return nil, notFoundError("session not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// List はユーザーの有効なセッションを最終アクセスの新しい順に返す
+func (s *SessionStore) List(userID int) ([]Session, error) {
+	rows, err := s.db.Query(`
+		SELECT `+sessionColumns+`
+		FROM sessions s
+		JOIN users u ON s.user_id = u.id
+		WHERE s.user_id = $1 AND s.revoked_at IS NULL AND s.expires_at > CURRENT_TIMESTAMP
+		ORDER BY s.last_seen_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, rows.Err()
+}
+
+// Touch はセッションの最終アクセス日時とIPアドレスを更新する。
+// 前回の更新から sessionTouchInterval 以内の場合は何もしない。
+func (s *SessionStore) Touch(id, ipAddress string) error {
+	_, err := s.db.Exec(`
+		UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP, ip_address = $2
+		WHERE id = $1 AND last_seen_at < $3`, id, ipAddress, time.Now().Add(-sessionTouchInterval))
+	return err
+}
+
+// Rotate はリフレッシュトークンを検証して新しいリフレッシュトークンに交換し、セッションの有効期限を延長する。
+// 交換済みの古いトークンが使われた場合は盗用とみなしてセッションを失効させる。
+// ただし猶予期間内に直前のトークンが再提示された場合は、交換せずにセッションのみを返す（新しいトークンは空文字）。
+func (s *SessionStore) Rotate(refreshToken string, lifetime time.Duration) (*Session, string, error) {
+	id, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || id == "" || secret == "" {
+		return nil, "", errors.New("invalid refresh token")
+	}
+
+	var currentHash string
+	var previousHash sql.NullString
+	var rotatedAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT refresh_token_hash, previous_refresh_token_hash, rotated_at
+		FROM sessions
+		WHERE id = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP`, id).
+		Scan(&currentHash, &previousHash, &rotatedAt)
+	if err == sql.ErrNoRows {
+		return nil, "", errors.New("invalid refresh token")
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	hash := hashToken(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(currentHash)) != 1 {
+		return s.staleRefreshToken(id, hash, previousHash, rotatedAt)
+	}
+
+	newSecret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+	// 同じトークンでの同時の交換はどちらか一方だけが成功するよう、交換前のトークンを条件にする
+	result, err := s.db.Exec(`
+		UPDATE sessions
+		SET previous_refresh_token_hash = refresh_token_hash,
+		    refresh_token_hash = $1,
+		    rotated_at = CURRENT_TIMESTAMP,
+		    expires_at = $2
+		WHERE id = $3 AND refresh_token_hash = $4 AND revoked_at IS NULL`,
+		hashToken(newSecret), time.Now().Add(lifetime), id, hash)
+	if err != nil {
+		return nil, "", err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, "", err
+	}
+	if rows == 0 {
+		// 検証後に他のリクエストが交換した: 交換後の状態を読み直し、猶予期間内なら同時の更新として扱う
+		err := s.db.QueryRow(`
+			SELECT previous_refresh_token_hash, rotated_at
+			FROM sessions
+			WHERE id = $1 AND revoked_at IS NULL`, id).Scan(&previousHash, &rotatedAt)
+		if err == sql.ErrNoRows {
+			return nil, "", errors.New("invalid refresh token")
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		return s.staleRefreshToken(id, hash, previousHash, rotatedAt)
+	}
+
+	session, err := s.Get(id)
+	if err != nil {
+		return nil, "", err
+	}
+	return session, id + "." + newSecret, nil
+}
+
+// staleRefreshToken は現在のトークンと一致しなかったリフレッシュトークンを扱う。
+// 猶予期間内の直前のトークンであればセッションのみを返し、それ以外は交換済みのトークンの再利用とみなしてセッションごと失効させる。
+func (s *SessionStore) staleRefreshToken(id, hash string, previousHash sql.NullString, rotatedAt sql.NullTime) (*Session, string, error) {
+	if previousHash.Valid && rotatedAt.Valid && time.Since(rotatedAt.Time) < refreshGracePeriod &&
+		subtle.ConstantTimeCompare([]byte(hash), []byte(previousHash.String)) == 1 {
+		session, err := s.Get(id)
+		return session, "", err
+	}
+	if err := s.Revoke(id); err != nil {
+		return nil, "", err
+	}
+	return nil, "", errors.New("refresh token reused")
+}
+
+// Revoke はセッションを失効させる
+func (s *SessionStore) Revoke(id string) error {
+	_, err := s.db.Exec(`
+		UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND revoked_at IS NULL`, id)
+	return err
+}
+
+// RevokeForUser はユーザー自身のセッションを失効させる
+func (s *SessionStore) RevokeForUser(id string, userID int) error {
+	result, err := s.db.Exec(`
+		UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, id, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return notFoundError("session not found")
+	}
+	return nil
+}
+
+// RevokeOthers は keepID 以外のユーザーのセッションを失効させる
+func (s *SessionStore) RevokeOthers(userID int, keepID string) error {
+	_, err := s.db.Exec(`
+		UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL`, userID, keepID)
+	return err
+}
+
+// RevokeAll はユーザーのすべてのセッションを失効させる（すべての端末からログアウト）
+func (s *SessionStore) RevokeAll(userID int) error {
+	_, err := s.db.Exec(`
+		UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	return err
+}
+
+const sessionColumns = `s.id, s.user_id, u.username, u.role, s.user_agent, s.ip_address,
+		s.created_at, s.last_seen_at, s.expires_at, ` + suspensionColumns
+
+func scanSession(row rowScanner) (*Session, error) {
+	var session Session
+	var suspension suspensionScan
+	err := row.Scan(&session.ID, &session.UserID, &session.Username, &session.Role, &session.UserAgent, &session.IPAddress,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt, &suspension.since, &suspension.until, &suspension.reason)
+	if err != nil {
+		return nil, err
+	}
+	session.Suspension = suspension.value()
+	return &session, nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
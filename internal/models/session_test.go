@@ -0,0 +1,228 @@
+package models
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func setupTestSessionUser(t *testing.T, store *SessionStore) {
+	t.Helper()
+	_, err := store.db.Exec(`INSERT INTO users (id, username, password_hash) VALUES (1, 'testuser', 'testhash')`)
+	if err != nil {
+		t.Fatalf("テストユーザーの作成に失敗しました: %v", err)
+	}
+}
+
+func TestSessionStore_CreateAndGet(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewSessionStore(db)
+	setupTestSessionUser(t, store)
+
+	session, refreshToken, err := store.Create(1, "test-agent", "127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("セッションの作成に失敗しました: %v", err)
+	}
+	if !strings.HasPrefix(refreshToken, session.ID+".") {
+		t.Errorf("リフレッシュトークンにセッションIDが含まれていません")
+	}
+
+	got, err := store.Get(session.ID)
+	if err != nil {
+		t.Fatalf("セッションの取得に失敗しました: %v", err)
+	}
+	if got.Username != "testuser" {
+		t.Errorf("ユーザー名が'testuser'であるべきですが、実際は'%s'です", got.Username)
+	}
+
+	// 期限切れのセッションは取得できない
+	expired, _, err := store.Create(1, "test-agent", "127.0.0.1", -time.Minute)
+	if err != nil {
+		t.Fatalf("セッションの作成に失敗しました: %v", err)
+	}
+	if _, err := store.Get(expired.ID); err == nil {
+		t.Error("期限切れのセッションを取得できてしまいました")
+	}
+}
+
+func TestSessionStore_Rotate(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewSessionStore(db)
+	setupTestSessionUser(t, store)
+
+	session, first, err := store.Create(1, "test-agent", "127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("セッションの作成に失敗しました: %v", err)
+	}
+
+	// リフレッシュトークンの交換
+	_, second, err := store.Rotate(first, time.Hour)
+	if err != nil {
+		t.Fatalf("リフレッシュトークンの交換に失敗しました: %v", err)
+	}
+	if second == "" || second == first {
+		t.Fatalf("新しいリフレッシュトークンが発行されていません")
+	}
+
+	// 猶予期間内の直前のトークンは交換されずに受け付けられる
+	_, third, err := store.Rotate(first, time.Hour)
+	if err != nil {
+		t.Fatalf("猶予期間内の再提示が拒否されました: %v", err)
+	}
+	if third != "" {
+		t.Errorf("猶予期間内の再提示で新しいトークンが発行されました")
+	}
+
+	// 交換済みのトークンの再利用はセッションを失効させる
+	if _, err := db.Exec(`UPDATE sessions SET rotated_at = CURRENT_TIMESTAMP - INTERVAL '1 hour'`); err != nil {
+		t.Fatalf("テストデータの更新に失敗しました: %v", err)
+	}
+	if _, _, err := store.Rotate(first, time.Hour); err == nil {
+		t.Error("交換済みのトークンを再利用できてしまいました")
+	}
+	if _, err := store.Get(session.ID); err == nil {
+		t.Error("トークンの再利用後もセッションが有効です")
+	}
+	if _, _, err := store.Rotate(second, time.Hour); err == nil {
+		t.Error("失効したセッションのトークンを交換できてしまいました")
+	}
+}
+
+// 同じトークンで同時に更新しても、交換は1回だけ行われ、セッションは失効しない
+func TestSessionStore_RotateConcurrent(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewSessionStore(db)
+	setupTestSessionUser(t, store)
+
+	session, refreshToken, err := store.Create(1, "test-agent", "127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("セッションの作成に失敗しました: %v", err)
+	}
+
+	const requests = 5
+	var wg sync.WaitGroup
+	tokens := make([]string, requests)
+	errs := make([]error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, tokens[i], errs[i] = store.Rotate(refreshToken, time.Hour)
+		}(i)
+	}
+	wg.Wait()
+
+	rotated := 0
+	for i := 0; i < requests; i++ {
+		if errs[i] != nil {
+			t.Errorf("同時の更新が拒否されました: %v", errs[i])
+		}
+		if tokens[i] != "" {
+			rotated++
+		}
+	}
+	if rotated != 1 {
+		t.Errorf("交換は1回であるべきですが、実際は%d回です", rotated)
+	}
+	if _, err := store.Get(session.ID); err != nil {
+		t.Errorf("同時の更新でセッションが失効しました: %v", err)
+	}
+}
+
+func TestSessionStore_RevokeAll(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewSessionStore(db)
+	setupTestSessionUser(t, store)
+
+	first, _, err := store.Create(1, "test-agent", "127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("セッションの作成に失敗しました: %v", err)
+	}
+	second, _, err := store.Create(1, "test-agent", "127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("セッションの作成に失敗しました: %v", err)
+	}
+
+	if err := store.Revoke(first.ID); err != nil {
+		t.Fatalf("セッションの失効に失敗しました: %v", err)
+	}
+	if _, err := store.Get(first.ID); err == nil {
+		t.Error("失効したセッションを取得できてしまいました")
+	}
+	if _, err := store.Get(second.ID); err != nil {
+		t.Error("失効させていないセッションが取得できません")
+	}
+
+	if err := store.RevokeAll(1); err != nil {
+		t.Fatalf("全セッションの失効に失敗しました: %v", err)
+	}
+	if _, err := store.Get(second.ID); err == nil {
+		t.Error("すべての端末からのログアウト後もセッションが有効です")
+	}
+}
+
+func TestSessionStore_ListAndRevokeForUser(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewSessionStore(db)
+	setupTestSessionUser(t, store)
+	if _, err := db.Exec(`INSERT INTO users (id, username, password_hash) VALUES (2, 'otheruser', 'testhash')`); err != nil {
+		t.Fatalf("テストユーザーの作成に失敗しました: %v", err)
+	}
+
+	session, _, err := store.Create(1, "Mozilla/5.0 (Windows NT 10.0) Firefox/120.0", "192.0.2.1", time.Hour)
+	if err != nil {
+		t.Fatalf("セッションの作成に失敗しました: %v", err)
+	}
+	if _, _, err := store.Create(1, "curl/8.0", "192.0.2.2", time.Hour); err != nil {
+		t.Fatalf("セッションの作成に失敗しました: %v", err)
+	}
+
+	sessions, err := store.List(1)
+	if err != nil {
+		t.Fatalf("セッション一覧の取得に失敗しました: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("セッション数が2であるべきですが、実際は%dです", len(sessions))
+	}
+
+	// 最終アクセスが古いセッションはIPアドレスとともに更新される
+	if _, err := db.Exec(`UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP - INTERVAL '1 hour' WHERE id = $1`, session.ID); err != nil {
+		t.Fatalf("最終アクセス日時の更新に失敗しました: %v", err)
+	}
+	if err := store.Touch(session.ID, "198.51.100.1"); err != nil {
+		t.Fatalf("最終アクセス日時の更新に失敗しました: %v", err)
+	}
+	got, err := store.Get(session.ID)
+	if err != nil {
+		t.Fatalf("セッションの取得に失敗しました: %v", err)
+	}
+	if got.IPAddress != "198.51.100.1" {
+		t.Errorf("IPアドレスが'198.51.100.1'であるべきですが、実際は'%s'です", got.IPAddress)
+	}
+
+	// 他のユーザーのセッションは失効できない
+	if err := store.RevokeForUser(session.ID, 2); err == nil {
+		t.Error("他のユーザーのセッションを失効できてしまいました")
+	}
+	if err := store.RevokeForUser(session.ID, 1); err != nil {
+		t.Fatalf("セッションの失効に失敗しました: %v", err)
+	}
+	sessions, err = store.List(1)
+	if err != nil {
+		t.Fatalf("セッション一覧の取得に失敗しました: %v", err)
+	}
+	if len(sessions) != 1 {
+		t.Errorf("失効後のセッション数が1であるべきですが、実際は%dです", len(sessions))
+	}
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Suspension はユーザーの利用停止の内容
+type Suspension struct {
+	Since time.Time `json:"since"`
+	// Until は利用停止の終了日時。nil の場合は無期限（BAN）。
+	Until  *time.Time `json:"until,omitempty"`
+	Reason string     `json:"reason"`
+}
+
+// SuspendedError は利用停止中のユーザーの認証を拒否したことを表す
+type SuspendedError struct {
+	Suspension *Suspension
+}
+
+func (e *SuspendedError) Error() string {
+	return "account suspended"
+}
+
+func (e *SuspendedError) Unwrap() error { return ErrForbidden }
+
+// suspensionColumns は users u の利用停止に関する列。
+// 終了日時を過ぎた利用停止は開始日時を NULL として読み込み、利用停止中でないものとして扱う。
+const suspensionColumns = `CASE WHEN u.suspended_until IS NULL OR u.suspended_until > CURRENT_TIMESTAMP
+			THEN u.suspended_at END, u.suspended_until, COALESCE(u.suspension_reason, '')`
+
+// suspensionScan は suspensionColumns の読み込み先
+type suspensionScan struct {
+	since  sql.NullTime
+	until  sql.NullTime
+	reason string
+}
+
+// value は利用停止中の場合にその内容を返す。利用停止中でなければ nil。
+func (s *suspensionScan) value() *Suspension {
+	if !s.since.Valid {
+		return nil
+	}
+	suspension := &Suspension{Since: s.since.Time, Reason: s.reason}
+	if s.until.Valid {
+		suspension.Until = &s.until.Time
+	}
+	return suspension
+}
@@ -0,0 +1,201 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Scope はパーソナルアクセストークンに許可する操作の範囲
+type Scope string
+
+const (
+	// ScopeRead は閲覧と検索のみ
+	ScopeRead Scope = "read"
+	// ScopeWrite は投稿・編集・削除（read を含む）
+	ScopeWrite Scope = "write"
+	// ScopeAdmin はトークン管理などアカウントの設定（write を含む）
+	ScopeAdmin Scope = "admin"
+)
+
+// AllScopes は定義されているすべてのスコープ
+var AllScopes = []Scope{ScopeRead, ScopeWrite, ScopeAdmin}
+
+// TokenPrefix はパーソナルアクセストークンの先頭に付く文字列。
+// JWTと区別するために使う。
+const TokenPrefix = "mbp_"
+
+// AccessToken はパーソナルアクセストークンを表す。平文のトークンは作成時にのみ返され、保存されない。
+type AccessToken struct {
+	ID       int    `json:"id"`
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Role     Role   `json:"-"`
+	// Suspension はユーザーが利用停止中の場合の内容
+	Suspension *Suspension `json:"-"`
+	Name       string      `json:"name"`
+	Hint       string      `json:"hint"`
+	Scopes     []Scope     `json:"scopes"`
+	LastUsedAt *time.Time  `json:"last_used_at"`
+	ExpiresAt  *time.Time  `json:"expires_at"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+// ScopeIncludes は granted のスコープが required の操作を含むかを返す
+func ScopeIncludes(granted, required Scope) bool {
+	rank := map[Scope]int{ScopeRead: 1, ScopeWrite: 2, ScopeAdmin: 3}
+	return rank[granted] != 0 && rank[granted] >= rank[required]
+}
+
+// ParseScope は文字列をスコープに変換する
+func ParseScope(s string) (Scope, bool) {
+	for _, scope := range AllScopes {
+		if string(scope) == s {
+			return scope, true
+		}
+	}
+	return "", false
+}
+
+type TokenStore struct {
+	db *sql.DB
+}
+
+func NewTokenStore(db *sql.DB) *TokenStore {
+	return &TokenStore{db: db}
+}
+
+// Create は新しいトークンを発行し、平文のトークンを返す。expiresAt が nil の場合は無期限。
+func (s *TokenStore) Create(userID int, name string, scopes []Scope, expiresAt *time.Time) (string, error) {
+	if len(scopes) == 0 {
+		return "", validationError("at least one scope is required")
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	token := TokenPrefix + secret
+
+	_, err = s.db.Exec(`
+		INSERT INTO tokens (user_id, name, token_hash, hint, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		userID, name, hashToken(token), token[:len(TokenPrefix)+4], pq.Array(scopeStrings(scopes)), expiresAt)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// List はユーザーの有効な（失効していない）トークンを新しい順に返す
+func (s *TokenStore) List(userID int) ([]AccessToken, error) {
+	rows, err := s.db.Query(`
+		SELECT t.id, t.user_id, u.username, u.role, t.name, t.hint, t.scopes, t.last_used_at, t.expires_at, t.created_at, `+suspensionColumns+`
+		FROM tokens t
+		JOIN users u ON t.user_id = u.id
+		WHERE t.user_id = $1 AND t.revoked_at IS NULL
+		ORDER BY t.created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []AccessToken
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// Revoke はユーザー自身のトークンを失効させる
+func (s *TokenStore) Revoke(id, userID int) error {
+	result, err := s.db.Exec(`
+		UPDATE tokens SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, id, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return notFoundError("token not found")
+	}
+	return nil
+}
+
+// Authenticate は平文のトークンを検証し、有効であれば最終使用日時を更新して返す
+func (s *TokenStore) Authenticate(token string) (*AccessToken, error) {
+	if !strings.HasPrefix(token, TokenPrefix) {
+		return nil, errors.New("invalid token")
+	}
+
+	t, err := scanToken(s.db.QueryRow(`
+		UPDATE tokens t SET last_used_at = CURRENT_TIMESTAMP
+		FROM users u
+		WHERE t.user_id = u.id
+		  AND t.token_hash = $1
+		  AND t.revoked_at IS NULL
+		  AND (t.expires_at IS NULL OR t.expires_at > CURRENT_TIMESTAMP)
+		RETURNING t.id, t.user_id, u.username, u.role, t.name, t.hint, t.scopes, t.last_used_at, t.expires_at, t.created_at, `+suspensionColumns,
+		hashToken(token)))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("invalid token")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanToken(row rowScanner) (*AccessToken, error) {
+	var t AccessToken
+	var scopes []string
+	var lastUsedAt, expiresAt sql.NullTime
+	var suspension suspensionScan
+	err := row.Scan(&t.ID, &t.UserID, &t.Username, &t.Role, &t.Name, &t.Hint, pq.Array(&scopes), &lastUsedAt, &expiresAt, &t.CreatedAt,
+		&suspension.since, &suspension.until, &suspension.reason)
+	if err != nil {
+		return nil, err
+	}
+	t.Suspension = suspension.value()
+	for _, s := range scopes {
+		t.Scopes = append(t.Scopes, Scope(s))
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	return &t, nil
+}
+
+// hashToken はトークンのSHA-256ハッシュを返す。
+// トークンは十分な長さの乱数なので、パスワードのような低速なハッシュは不要。
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func scopeStrings(scopes []Scope) []string {
+	s := make([]string, len(scopes))
+	for i, scope := range scopes {
+		s[i] = string(scope)
+	}
+	return s
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenStore_CreateAndAuthenticate(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewTokenStore(db)
+
+	_, err := db.Exec(`INSERT INTO users (id, username, password_hash) VALUES (1, 'testuser', 'testhash')`)
+	if err != nil {
+		t.Fatalf("テストユーザーの作成に失敗しました: %v", err)
+	}
+
+	// トークンの発行
+	token, err := store.Create(1, "CIボット", []Scope{ScopeRead}, nil)
+	if err != nil {
+		t.Fatalf("トークンの発行に失敗しました: %v", err)
+	}
+	if !strings.HasPrefix(token, TokenPrefix) {
+		t.Errorf("トークンが'%s'で始まるべきですが、実際は'%s'です", TokenPrefix, token)
+	}
+
+	// 平文のトークンは保存されない
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM tokens WHERE token_hash = $1", token).Scan(&count)
+	if err != nil {
+		t.Fatalf("トークンの取得に失敗しました: %v", err)
+	}
+	if count != 0 {
+		t.Error("平文のトークンが保存されています")
+	}
+
+	// トークンの認証
+	at, err := store.Authenticate(token)
+	if err != nil {
+		t.Fatalf("トークンの認証に失敗しました: %v", err)
+	}
+	if at.Username != "testuser" || at.Name != "CIボット" {
+		t.Errorf("トークンの情報が正しくありません: %+v", at)
+	}
+	if at.LastUsedAt == nil {
+		t.Error("最終使用日時が更新されていません")
+	}
+	if len(at.Scopes) != 1 || at.Scopes[0] != ScopeRead {
+		t.Errorf("スコープが正しくありません: %v", at.Scopes)
+	}
+
+	// 誤ったトークンは拒否される
+	if _, err := store.Authenticate(TokenPrefix + "invalid"); err == nil {
+		t.Error("誤ったトークンで認証できてしまいました")
+	}
+}
+
+func TestTokenStore_Expired(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewTokenStore(db)
+
+	_, err := db.Exec(`INSERT INTO users (id, username, password_hash) VALUES (1, 'testuser', 'testhash')`)
+	if err != nil {
+		t.Fatalf("テストユーザーの作成に失敗しました: %v", err)
+	}
+
+	expired := time.Now().Add(-time.Hour)
+	token, err := store.Create(1, "期限切れ", []Scope{ScopeWrite}, &expired)
+	if err != nil {
+		t.Fatalf("トークンの発行に失敗しました: %v", err)
+	}
+
+	if _, err := store.Authenticate(token); err == nil {
+		t.Error("期限切れのトークンで認証できてしまいました")
+	}
+}
+
+func TestTokenStore_Revoke(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewTokenStore(db)
+
+	_, err := db.Exec(`INSERT INTO users (id, username, password_hash) VALUES (1, 'testuser', 'testhash'), (2, 'otheruser', 'testhash')`)
+	if err != nil {
+		t.Fatalf("テストユーザーの作成に失敗しました: %v", err)
+	}
+
+	token, err := store.Create(1, "失効テスト", []Scope{ScopeAdmin}, nil)
+	if err != nil {
+		t.Fatalf("トークンの発行に失敗しました: %v", err)
+	}
+
+	tokens, err := store.List(1)
+	if err != nil || len(tokens) != 1 {
+		t.Fatalf("トークン一覧の取得に失敗しました: %v", err)
+	}
+
+	// 他のユーザーは失効させられない
+	if err := store.Revoke(tokens[0].ID, 2); err == nil {
+		t.Error("他のユーザーのトークンを失効できてしまいました")
+	}
+
+	if err := store.Revoke(tokens[0].ID, 1); err != nil {
+		t.Fatalf("トークンの失効に失敗しました: %v", err)
+	}
+	if _, err := store.Authenticate(token); err == nil {
+		t.Error("失効したトークンで認証できてしまいました")
+	}
+
+	tokens, err = store.List(1)
+	if err != nil {
+		t.Fatalf("トークン一覧の取得に失敗しました: %v", err)
+	}
+	if len(tokens) != 0 {
+		t.Errorf("失効したトークンが一覧に含まれています")
+	}
+}
+
+func TestScopeIncludes(t *testing.T) {
+	tests := []struct {
+		granted, required Scope
+		want              bool
+	}{
+		{ScopeRead, ScopeRead, true},
+		{ScopeRead, ScopeWrite, false},
+		{ScopeWrite, ScopeRead, true},
+		{ScopeWrite, ScopeAdmin, false},
+		{ScopeAdmin, ScopeWrite, true},
+		{Scope("unknown"), ScopeRead, false},
+	}
+
+	for _, tt := range tests {
+		if got := ScopeIncludes(tt.granted, tt.required); got != tt.want {
+			t.Errorf("ScopeIncludes(%s, %s) が%vであるべきですが、実際は%vです", tt.granted, tt.required, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"message-board/internal/totp"
+)
+
+// 有効化時に発行するリカバリーコードの数
+const recoveryCodeCount = 10
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactorStore はTOTPによる2段階認証の設定とリカバリーコードを管理する
+type TwoFactorStore struct {
+	db *sql.DB
+}
+
+func NewTwoFactorStore(db *sql.DB) *TwoFactorStore {
+	return &TwoFactorStore{db: db}
+}
+
+// Begin は新しいシークレットを生成し、有効化前の状態で保存する。
+// すでに2段階認証が有効な場合はエラーを返す。
+func (s *TwoFactorStore) Begin(userID int) (string, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE users SET totp_secret = $1, totp_last_step = NULL
+		WHERE id = $2 AND totp_enabled_at IS NULL`, secret, userID)
+	if err != nil {
+		return "", err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if rows == 0 {
+		return "", conflictError("two-factor authentication already enabled")
+	}
+	return secret, nil
+}
+
+// PendingSecret は有効化前のシークレットを返す
+func (s *TwoFactorStore) PendingSecret(userID int) (string, error) {
+	var secret sql.NullString
+	err := s.db.QueryRow(`
+		SELECT totp_secret FROM users
+		WHERE id = $1 AND totp_enabled_at IS NULL`, userID).Scan(&secret)
+	if err == sql.ErrNoRows || (err == nil && !secret.Valid) {
+		return "", conflictError("two-factor setup not started")
+	}
+	if err != nil {
+		return "", err
+	}
+	return secret.String, nil
+}
+
+// Enable は確認コードを検証して2段階認証を有効にし、平文のリカバリーコードを返す
+func (s *TwoFactorStore) Enable(userID int, code string) ([]string, error) {
+	secret, err := s.PendingSecret(userID)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return nil, errors.New("invalid code")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users SET totp_enabled_at = CURRENT_TIMESTAMP, totp_last_step = $1
+		WHERE id = $2`, step, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// Disable は2段階認証を無効にし、シークレットとリカバリーコードを削除する
+func (s *TwoFactorStore) Disable(userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL
+		WHERE id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Verify はログイン時のTOTPコードを検証する。
+// 一度使われたコード（およびそれ以前のステップのコード）は再利用できない。
+func (s *TwoFactorStore) Verify(userID int, code string) error {
+	var secret string
+	var lastStep sql.NullInt64
+	err := s.db.QueryRow(`
+		SELECT totp_secret, totp_last_step FROM users
+		WHERE id = $1 AND totp_enabled_at IS NOT NULL`, userID).Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		return conflictError("two-factor authentication not enabled")
+	}
+	if err != nil {
+		return err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok || (lastStep.Valid && step <= lastStep.Int64) {
+		return errors.New("invalid code")
+	}
+
+	// 同時に同じコードが使われた場合に備えて条件付きで更新する
+	result, err := s.db.Exec(`
+		UPDATE users SET totp_last_step = $1
+		WHERE id = $2 AND (totp_last_step IS NULL OR totp_last_step < $1)`, step, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return errors.New("invalid code")
+	}
+	return nil
+}
+
+// UseRecoveryCode は未使用のリカバリーコードを消費する
+func (s *TwoFactorStore) UseRecoveryCode(userID int, code string) error {
+	result, err := s.db.Exec(`
+		UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return errors.New("invalid code")
+	}
+	return nil
+}
+
+// RemainingRecoveryCodes は未使用のリカバリーコードの数を返す
+func (s *TwoFactorStore) RemainingRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM recovery_codes
+		WHERE user_id = $1 AND used_at IS NULL`, userID).Scan(&count)
+	return count, err
+}
+
+// replaceRecoveryCodes は既存のリカバリーコードを削除して新しいコードを発行する
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO recovery_codes (user_id, code_hash)
+			VALUES ($1, $2)`, userID, hashToken(normalizeRecoveryCode(code)))
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+	return codes, nil
+}
+
+// generateRecoveryCode は "xxxxx-xxxxx" 形式のリカバリーコードを生成する
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	s := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+	return s[:5] + "-" + s[5:], nil
+}
+
+// normalizeRecoveryCode は入力の揺れ（大文字小文字、ハイフン、空白）を吸収する
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.Join(strings.Fields(code), "")
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"message-board/internal/totp"
+)
+
+func TestTwoFactorStore_EnableAndVerify(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	userStore := NewUserStore(db)
+	store := NewTwoFactorStore(db)
+
+	if err := userStore.Create("testuser", "password123"); err != nil {
+		t.Fatalf("テストユーザーの作成に失敗しました: %v", err)
+	}
+	user, err := userStore.GetByUsername("testuser")
+	if err != nil {
+		t.Fatalf("ユーザー取得に失敗しました: %v", err)
+	}
+
+	secret, err := store.Begin(user.ID)
+	if err != nil {
+		t.Fatalf("2段階認証の設定開始に失敗しました: %v", err)
+	}
+
+	// 誤ったコードでは有効化できない
+	if _, err := store.Enable(user.ID, "000000"); err == nil {
+		t.Error("誤ったコードで有効化できてしまいました")
+	}
+
+	// 前のステップのコードで有効化し、現在のステップのコードをログインに使う
+	enrollCode, _ := totp.Code(secret, time.Now().Add(-totp.Period))
+	codes, err := store.Enable(user.ID, enrollCode)
+	if err != nil {
+		t.Fatalf("2段階認証の有効化に失敗しました: %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Errorf("リカバリーコードが%d個であるべきですが、実際は%d個です", recoveryCodeCount, len(codes))
+	}
+
+	user, err = userStore.GetByID(user.ID)
+	if err != nil {
+		t.Fatalf("ユーザー取得に失敗しました: %v", err)
+	}
+	if !user.TwoFactorEnabled {
+		t.Error("2段階認証が有効になっていません")
+	}
+	if _, err := store.Begin(user.ID); err == nil {
+		t.Error("有効化済みのユーザーが設定をやり直せてしまいました")
+	}
+
+	code, _ := totp.Code(secret, time.Now())
+	if err := store.Verify(user.ID, code); err != nil {
+		t.Errorf("正しいコードの検証に失敗しました: %v", err)
+	}
+	// 同じコードは再利用できない
+	if err := store.Verify(user.ID, code); err == nil {
+		t.Error("使用済みのコードを再利用できてしまいました")
+	}
+
+	// リカバリーコードは一度だけ使える（前後の空白は無視する）
+	if err := store.UseRecoveryCode(user.ID, "  "+codes[0]+" "); err != nil {
+		t.Errorf("リカバリーコードの使用に失敗しました: %v", err)
+	}
+	if err := store.UseRecoveryCode(user.ID, codes[0]); err == nil {
+		t.Error("使用済みのリカバリーコードを再利用できてしまいました")
+	}
+	remaining, err := store.RemainingRecoveryCodes(user.ID)
+	if err != nil {
+		t.Fatalf("リカバリーコード数の取得に失敗しました: %v", err)
+	}
+	if remaining != recoveryCodeCount-1 {
+		t.Errorf("残りのリカバリーコードが%d個であるべきですが、実際は%d個です", recoveryCodeCount-1, remaining)
+	}
+
+	if err := store.Disable(user.ID); err != nil {
+		t.Fatalf("2段階認証の無効化に失敗しました: %v", err)
+	}
+	user, err = userStore.GetByID(user.ID)
+	if err != nil {
+		t.Fatalf("ユーザー取得に失敗しました: %v", err)
+	}
+	if user.TwoFactorEnabled {
+		t.Error("2段階認証が無効になっていません")
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	if got := normalizeRecoveryCode(" ABCDE-fghij "); got != "abcdefghij" {
+		t.Errorf("正規化結果が'abcdefghij'であるべきですが、実際は'%s'です", got)
+	}
+}
@@ -5,14 +5,39 @@ import (
 	"errors"
 	"time"
 
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	ID           int       `json:"id"`
-	Username     string    `json:"username"`
-	PasswordHash string    `json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           int    `json:"id"`
+	Username     string `json:"username"`
+	PasswordHash string `json:"-"`
+	// Email は未登録の場合は空文字列
+	Email           string     `json:"email,omitempty"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	// TwoFactorEnabled はTOTPによる2段階認証が有効かどうか
+	TwoFactorEnabled bool `json:"two_factor_enabled"`
+	Role             Role `json:"role"`
+	// Suspension は利用停止中の場合の内容。利用停止中でなければ nil。
+	Suspension *Suspension `json:"suspension,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+// Suspended はユーザーが利用停止中かを返す
+func (u *User) Suspended() bool {
+	return u.Suspension != nil
+}
+
+// EmailVerified はメールアドレスが確認済みかを返す
+func (u *User) EmailVerified() bool {
+	return u.Email != "" && u.EmailVerifiedAt != nil
+}
+
+// HasPassword はパスワードが設定されているかを返す。
+// シングルサインオンで作成されたアカウントは、パスワードを設定するまでパスワードを持たない。
+func (u *User) HasPassword() bool {
+	return u.PasswordHash != ""
 }
 
 type UserStore struct {
@@ -24,47 +49,217 @@ func NewUserStore(db *sql.DB) *UserStore {
 }
 
 func (s *UserStore) Create(username, password string) error {
+	_, err := s.CreateWithEmail(username, password, "")
+	return err
+}
+
+// CreateWithEmail はメールアドレス付きでユーザーを作成し、作成したユーザーのIDを返す。
+// email が空の場合はメールアドレスを登録しない。他のユーザーが確認済みのアドレスは登録できない。
+func (s *UserStore) CreateWithEmail(username, password, email string) (int, error) {
+	if taken, err := s.emailTaken(email, 0); err != nil || taken {
+		if err == nil {
+			err = ErrEmailInUse
+		}
+		return 0, err
+	}
+
 	// パスワードハッシュを生成
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	// ユーザーを挿入
-	_, err = s.db.Exec(`
-		INSERT INTO users (username, password_hash)
-		VALUES ($1, $2)
-	`, username, string(hashedPassword))
+	var id int
+	err = s.db.QueryRow(`
+		INSERT INTO users (username, password_hash, email)
+		VALUES ($1, $2, NULLIF($3, ''))
+		RETURNING id
+	`, username, string(hashedPassword), email).Scan(&id)
+	if isUniqueViolation(err, "users_username_key") {
+		return 0, ErrUsernameTaken
+	}
 
-	return err
+	return id, err
 }
 
 func (s *UserStore) GetByUsername(username string) (*User, error) {
 	var user User
+	var suspension suspensionScan
 	err := s.db.QueryRow(`
-		SELECT id, username, password_hash, created_at
-		FROM users
+		SELECT id, username, password_hash, COALESCE(email, ''), email_verified_at,
+			totp_enabled_at IS NOT NULL, role, `+suspensionColumns+`, created_at
+		FROM users u
 		WHERE username = $1
-	`, username).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.CreatedAt)
+	`, username).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Email, &user.EmailVerifiedAt,
+		&user.TwoFactorEnabled, &user.Role, &suspension.since, &suspension.until, &suspension.reason, &user.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, notFoundError("user not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.Suspension = suspension.value()
+	return &user, nil
+}
+
+func (s *UserStore) GetByID(id int) (*User, error) {
+	var user User
+	var suspension suspensionScan
+	err := s.db.QueryRow(`
+		SELECT id, username, password_hash, COALESCE(email, ''), email_verified_at,
+			totp_enabled_at IS NOT NULL, role, `+suspensionColumns+`, created_at
+		FROM users u
+		WHERE id = $1
+	`, id).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Email, &user.EmailVerifiedAt,
+		&user.TwoFactorEnabled, &user.Role, &suspension.since, &suspension.until, &suspension.reason, &user.CreatedAt)
 
 	if err == sql.ErrNoRows {
-		return nil, errors.New("user not found")
+		return nil, notFoundError("user not found")
 	}
 	if err != nil {
 		return nil, err
 	}
+	user.Suspension = suspension.value()
 	return &user, nil
 }
 
+// dummyPasswordHash は存在しないユーザーの認証でも同じだけ時間をかけるための比較対象
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
 func (s *UserStore) Authenticate(username, password string) (*User, error) {
 	user, err := s.GetByUsername(username)
 	if err != nil {
+		// ユーザーが存在しない場合も bcrypt の比較を行い、応答時間からユーザーの有無を推測できないようにする
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
 		return nil, err
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
 	if err != nil {
-		return nil, errors.New("invalid password")
+		return nil, ErrInvalidPassword
+	}
+	if user.Suspended() {
+		return nil, &SuspendedError{Suspension: user.Suspension}
 	}
 
 	return user, nil
 }
+
+// ChangePassword は現在のパスワードを確認してからパスワードを変更する
+func (s *UserStore) ChangePassword(userID int, currentPassword, newPassword string) error {
+	if err := s.VerifyPassword(userID, currentPassword); err != nil {
+		return err
+	}
+
+	return s.SetPassword(userID, newPassword)
+}
+
+// VerifyPassword はログイン中のユーザーのパスワードを確認する
+func (s *UserStore) VerifyPassword(userID int, password string) error {
+	user, err := s.GetByID(userID)
+	if err != nil {
+		return err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+	if err != nil {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+// SetPassword は現在のパスワードを確認せずにパスワードを設定する。
+// パスワード再設定トークンを検証した後などに使う。
+func (s *UserStore) SetPassword(userID int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE users SET password_hash = $1 WHERE id = $2
+	`, string(hashedPassword), userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return notFoundError("user not found")
+	}
+	return nil
+}
+
+// SetInitialPassword はパスワードのないアカウントに最初のパスワードを設定する。
+// すでにパスワードがある場合は、現在のパスワードの確認が必要なため設定しない。
+func (s *UserStore) SetInitialPassword(userID int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE users SET password_hash = $1 WHERE id = $2 AND password_hash = ''
+	`, string(hashedPassword), userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return conflictError("password already set")
+	}
+	return nil
+}
+
+// SetEmail はユーザーのメールアドレスを変更する。変更後のアドレスは未確認の状態になる。
+// 他のユーザーが確認済みのアドレスには変更できない。
+func (s *UserStore) SetEmail(userID int, email string) error {
+	if taken, err := s.emailTaken(email, userID); err != nil || taken {
+		if err == nil {
+			err = ErrEmailInUse
+		}
+		return err
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE users SET email = NULLIF($1, ''), email_verified_at = NULL WHERE id = $2
+	`, email, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return notFoundError("user not found")
+	}
+	return nil
+}
+
+// emailTaken は email が userID 以外のユーザーの確認済みのアドレスかを返す。
+// 未確認のアドレスは重複して登録でき、確認時に一意性を確かめる（users_email_idx）。
+func (s *UserStore) emailTaken(email string, userID int) (bool, error) {
+	if email == "" {
+		return false, nil
+	}
+	var taken bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM users
+			WHERE lower(email) = lower($1) AND email_verified_at IS NOT NULL AND id <> $2
+		)`, email, userID).Scan(&taken)
+	return taken, err
+}
+
+// isUniqueViolation は err が指定された制約の一意性違反かを返す
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
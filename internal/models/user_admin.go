@@ -0,0 +1,226 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 管理者によるユーザー操作としてモデレーションログに記録する操作
+const (
+	ModerationSuspendUser   = "user.suspend"
+	ModerationUnsuspendUser = "user.unsuspend"
+	ModerationResetPassword = "user.reset_password"
+	ModerationChangeRole    = "user.role"
+	ModerationDeleteUser    = "user.delete"
+)
+
+// UserSummary は管理画面に表示するユーザーの概要
+type UserSummary struct {
+	User
+	MessageCount int        `json:"message_count"`
+	PostCount    int        `json:"post_count"`
+	LastLoginAt  *time.Time `json:"last_login_at"`
+}
+
+// ListSummaries はユーザー名が query で始まるユーザーを登録順に1ページ分と総数を返す。
+// 最終ログイン日時は最後に作成されたセッションの日時。
+func (s *UserStore) ListSummaries(query string, page, perPage int) ([]UserSummary, int, error) {
+	pattern := escapeLike(query) + "%"
+
+	var total int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM users WHERE username ILIKE $1`, pattern).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * perPage
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, COALESCE(u.email, ''), u.email_verified_at,
+			u.totp_enabled_at IS NOT NULL, u.role, `+suspensionColumns+`, u.created_at,
+			(SELECT COUNT(*) FROM messages WHERE user_id = u.id AND deleted_at IS NULL),
+			(SELECT COUNT(*) FROM posts WHERE user_id = u.id AND deleted_at IS NULL),
+			(SELECT MAX(created_at) FROM sessions WHERE user_id = u.id)
+		FROM users u
+		WHERE u.username ILIKE $1
+		ORDER BY u.id
+		LIMIT $2 OFFSET $3`, pattern, perPage, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []UserSummary
+	for rows.Next() {
+		var u UserSummary
+		var suspension suspensionScan
+		err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.EmailVerifiedAt,
+			&u.TwoFactorEnabled, &u.Role, &suspension.since, &suspension.until, &suspension.reason, &u.CreatedAt,
+			&u.MessageCount, &u.PostCount, &u.LastLoginAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		u.Suspension = suspension.value()
+		users = append(users, u)
+	}
+	return users, total, rows.Err()
+}
+
+// Suspend はユーザーの利用を until まで停止する。until が nil の場合は無期限に停止する（BAN）。
+// 利用停止中のユーザーはログインできず、発行済みのセッションやパーソナルアクセストークンも使えない。
+// reason は本人に表示される。
+func (s *UserStore) Suspend(actorID, userID int, until *time.Time, reason string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := suspendUser(tx, actorID, userID, until, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// suspendUser はトランザクション内でユーザーの利用を停止し、モデレーションログに記録する
+func suspendUser(tx *sql.Tx, actorID, userID int, until *time.Time, reason string) error {
+	result, err := tx.Exec(`
+		UPDATE users
+		SET suspended_at = CURRENT_TIMESTAMP, suspended_until = $2, suspension_reason = $3
+		WHERE id = $1`, userID, until, reason)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return notFoundError("user not found")
+	}
+
+	details := reason
+	if until != nil {
+		details = until.Format(time.RFC3339) + " " + reason
+	}
+	return logModeration(tx, actorID, ModerationSuspendUser, userID, userID, details)
+}
+
+// Unsuspend はユーザーの利用停止を解除する
+func (s *UserStore) Unsuspend(actorID, userID int) error {
+	return s.adminUpdate(actorID, userID, ModerationUnsuspendUser, "", false, `
+		UPDATE users SET suspended_at = NULL, suspended_until = NULL, suspension_reason = NULL WHERE id = $1`)
+}
+
+// SetRole はユーザーの役割を変更する
+func (s *UserStore) SetRole(actorID, userID int, role Role) error {
+	if _, ok := ParseRole(string(role)); !ok {
+		return validationError("invalid role")
+	}
+	// 役割はリクエストごとにデータベースから読み込むため、セッションを失効させる必要はない
+	return s.adminUpdate(actorID, userID, ModerationChangeRole, string(role), false, `
+		UPDATE users SET role = $2 WHERE id = $1`, role)
+}
+
+// ResetPassword はユーザーのパスワードを仮のパスワードに置き換え、すべてのセッションを失効させる。
+// 仮のパスワードは管理者からユーザーへ伝えるために一度だけ返す。
+func (s *UserStore) ResetPassword(actorID, userID int) (string, error) {
+	password, err := randomHex(8)
+	if err != nil {
+		return "", err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	err = s.adminUpdate(actorID, userID, ModerationResetPassword, "", true, `
+		UPDATE users SET password_hash = $2 WHERE id = $1`, string(hashedPassword))
+	if err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+// adminUpdate は管理者によるユーザーの更新を行い、モデレーションログに記録する。
+// revokeSessions が true の場合はユーザーのすべてのセッションを失効させる。
+// query の $1 はユーザーID、$2 以降は args。
+func (s *UserStore) adminUpdate(actorID, userID int, action, details string, revokeSessions bool, query string, args ...interface{}) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(query, append([]interface{}{userID}, args...)...)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return notFoundError("user not found")
+	}
+
+	if revokeSessions {
+		_, err = tx.Exec(`
+			UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+			WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := logModeration(tx, actorID, action, userID, userID, details); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete はユーザーを削除する。purge が true の場合はユーザーのスレッドも削除し、投稿は内容を空にして削除済みにする。
+// false の場合は投稿者を空にして（匿名化して）残す。
+// トークンやセッションなどユーザーに紐づくデータは外部キーの ON DELETE CASCADE で削除される。
+func (s *UserStore) Delete(actorID, userID int, purge bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var username string
+	err = tx.QueryRow(`SELECT username FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&username)
+	if err == sql.ErrNoRows {
+		return notFoundError("user not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	statements := []string{
+		`UPDATE posts SET user_id = NULL WHERE user_id = $1`,
+		`UPDATE messages SET user_id = NULL WHERE user_id = $1`,
+	}
+	details := username + " (anonymize)"
+	if purge {
+		statements = []string{
+			// 他のユーザーの返信を残すため、投稿は行を消さずに削除済みにする
+			`UPDATE posts SET content = '', deleted_at = CURRENT_TIMESTAMP, user_id = NULL WHERE user_id = $1`,
+			`DELETE FROM messages WHERE user_id = $1`,
+		}
+		details = username + " (purge)"
+	}
+	statements = append(statements, `DELETE FROM users WHERE id = $1`)
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, userID); err != nil {
+			return err
+		}
+	}
+
+	// 削除したユーザーは参照できないため、対象ユーザーは空にしてユーザー名を詳細に残す
+	if err := logModeration(tx, actorID, ModerationDeleteUser, userID, 0, details); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
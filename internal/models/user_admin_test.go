@@ -0,0 +1,202 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUserStore_Suspend(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewUserStore(db)
+	sessionStore := NewSessionStore(db)
+
+	if _, err := db.Exec(`INSERT INTO users (id, username, password_hash, role) VALUES (1, 'admin', 'testhash', 'admin')`); err != nil {
+		t.Fatalf("管理者の作成に失敗しました: %v", err)
+	}
+	userID, err := store.CreateWithEmail("testuser", "password123", "")
+	if err != nil {
+		t.Fatalf("テストユーザーの作成に失敗しました: %v", err)
+	}
+	session, _, err := sessionStore.Create(userID, "test-agent", "127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("セッションの作成に失敗しました: %v", err)
+	}
+
+	// 利用停止中はログインできず、発行済みのセッションからも利用停止の内容がわかる
+	until := time.Now().Add(24 * time.Hour)
+	if err := store.Suspend(1, userID, &until, "スパム投稿"); err != nil {
+		t.Fatalf("利用停止に失敗しました: %v", err)
+	}
+	_, err = store.Authenticate("testuser", "password123")
+	var suspendedErr *SuspendedError
+	if !errors.As(err, &suspendedErr) {
+		t.Fatalf("SuspendedError を期待しますが、実際は'%v'です", err)
+	}
+	if suspendedErr.Suspension.Reason != "スパム投稿" || suspendedErr.Suspension.Until == nil {
+		t.Errorf("利用停止の内容が正しくありません: %+v", suspendedErr.Suspension)
+	}
+	got, err := sessionStore.Get(session.ID)
+	if err != nil {
+		t.Fatalf("セッションの取得に失敗しました: %v", err)
+	}
+	if got.Suspension == nil {
+		t.Error("セッションに利用停止の内容が設定されていません")
+	}
+
+	// 解除するとログインできる
+	if err := store.Unsuspend(1, userID); err != nil {
+		t.Fatalf("利用停止の解除に失敗しました: %v", err)
+	}
+	if _, err := store.Authenticate("testuser", "password123"); err != nil {
+		t.Errorf("利用停止の解除後に認証できません: %v", err)
+	}
+
+	// 操作はモデレーションログに残る
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM moderation_log WHERE actor_id = 1 AND target_user_id = $1`, userID).Scan(&count)
+	if err != nil {
+		t.Fatalf("モデレーションログの取得に失敗しました: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("モデレーションログが2件であるべきですが、実際は%d件です", count)
+	}
+}
+
+func TestUserStore_SuspensionExpires(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewUserStore(db)
+	tokenStore := NewTokenStore(db)
+
+	if _, err := db.Exec(`INSERT INTO users (id, username, password_hash, role) VALUES (1, 'admin', 'testhash', 'admin')`); err != nil {
+		t.Fatalf("管理者の作成に失敗しました: %v", err)
+	}
+	userID, err := store.CreateWithEmail("testuser", "password123", "")
+	if err != nil {
+		t.Fatalf("テストユーザーの作成に失敗しました: %v", err)
+	}
+	token, err := tokenStore.Create(userID, "ci", []Scope{ScopeRead}, nil)
+	if err != nil {
+		t.Fatalf("トークンの作成に失敗しました: %v", err)
+	}
+
+	// 無期限の利用停止はパーソナルアクセストークンにも反映される
+	if err := store.Suspend(1, userID, nil, "規約違反"); err != nil {
+		t.Fatalf("利用停止に失敗しました: %v", err)
+	}
+	accessToken, err := tokenStore.Authenticate(token)
+	if err != nil {
+		t.Fatalf("トークンの検証に失敗しました: %v", err)
+	}
+	if accessToken.Suspension == nil || accessToken.Suspension.Until != nil {
+		t.Errorf("無期限の利用停止が設定されていません: %+v", accessToken.Suspension)
+	}
+
+	// 終了日時を過ぎた利用停止は無効になる
+	if _, err := db.Exec(`UPDATE users SET suspended_until = CURRENT_TIMESTAMP - INTERVAL '1 minute' WHERE id = $1`, userID); err != nil {
+		t.Fatalf("テストデータの更新に失敗しました: %v", err)
+	}
+	if _, err := store.Authenticate("testuser", "password123"); err != nil {
+		t.Errorf("利用停止の終了後に認証できません: %v", err)
+	}
+}
+
+func TestUserStore_SetRoleAndResetPassword(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewUserStore(db)
+
+	if _, err := db.Exec(`INSERT INTO users (id, username, password_hash, role) VALUES (1, 'admin', 'testhash', 'admin')`); err != nil {
+		t.Fatalf("管理者の作成に失敗しました: %v", err)
+	}
+	userID, err := store.CreateWithEmail("testuser", "password123", "")
+	if err != nil {
+		t.Fatalf("テストユーザーの作成に失敗しました: %v", err)
+	}
+
+	if err := store.SetRole(1, userID, RoleModerator); err != nil {
+		t.Fatalf("役割の変更に失敗しました: %v", err)
+	}
+	if err := store.SetRole(1, userID, Role("root")); err == nil {
+		t.Error("不明な役割に変更できてしまいました")
+	}
+	user, err := store.GetByID(userID)
+	if err != nil {
+		t.Fatalf("ユーザーの取得に失敗しました: %v", err)
+	}
+	if user.Role != RoleModerator {
+		t.Errorf("役割が'moderator'であるべきですが、実際は'%s'です", user.Role)
+	}
+
+	password, err := store.ResetPassword(1, userID)
+	if err != nil {
+		t.Fatalf("パスワードのリセットに失敗しました: %v", err)
+	}
+	if _, err := store.Authenticate("testuser", password); err != nil {
+		t.Errorf("仮のパスワードで認証できません: %v", err)
+	}
+	if _, err := store.Authenticate("testuser", "password123"); err == nil {
+		t.Error("リセット前のパスワードで認証できてしまいました")
+	}
+}
+
+func TestUserStore_Delete(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewUserStore(db)
+	messageStore := NewMessageStore(db)
+
+	_, err := db.Exec(`
+		INSERT INTO users (id, username, password_hash, role) VALUES (1, 'admin', 'testhash', 'admin');
+		INSERT INTO users (id, username, password_hash) VALUES (2, 'anonymized', 'testhash');
+		INSERT INTO users (id, username, password_hash) VALUES (3, 'purged', 'testhash');
+		INSERT INTO messages (id, title, content, user_id) VALUES (1, '残るタイトル', '残る内容', 2);
+		INSERT INTO messages (id, title, content, user_id) VALUES (2, '消えるタイトル', '消える内容', 3);
+		INSERT INTO posts (id, message_id, content, user_id) VALUES (1, 1, '消える投稿', 3);
+		INSERT INTO posts (id, message_id, parent_post_id, content, user_id) VALUES (2, 1, 1, '残る返信', 1);
+	`)
+	if err != nil {
+		t.Fatalf("テストデータの作成に失敗しました: %v", err)
+	}
+
+	// 匿名化: メッセージは投稿者なしで残る
+	if err := store.Delete(1, 2, false); err != nil {
+		t.Fatalf("ユーザーの削除に失敗しました: %v", err)
+	}
+	msg, err := messageStore.Get(1)
+	if err != nil {
+		t.Fatalf("匿名化したメッセージを取得できません: %v", err)
+	}
+	if msg.UserID != 0 || msg.Username != "" {
+		t.Errorf("投稿者が匿名化されていません: %d, '%s'", msg.UserID, msg.Username)
+	}
+
+	// 削除: メッセージも削除される
+	if err := store.Delete(1, 3, true); err != nil {
+		t.Fatalf("ユーザーの削除に失敗しました: %v", err)
+	}
+	if _, err := messageStore.Get(2); err == nil {
+		t.Error("削除したユーザーのメッセージが残っています")
+	}
+	// 投稿は削除済みになり、他のユーザーの返信は残る
+	postStore := NewPostStore(db)
+	if post, err := postStore.Get(1); err != nil || post.DeletedAt == nil || post.Content != "" {
+		t.Errorf("削除したユーザーの投稿が削除済みになっていません: %+v, %v", post, err)
+	}
+	if reply, err := postStore.Get(2); err != nil || reply.Content != "残る返信" {
+		t.Errorf("他のユーザーの返信が残っていません: %+v, %v", reply, err)
+	}
+
+	if _, err := store.GetByID(2); err == nil {
+		t.Error("削除したユーザーが残っています")
+	}
+	if err := store.Delete(1, 2, false); err == nil || err.Error() != "user not found" {
+		t.Errorf("'user not found'エラーを期待しますが、実際は'%v'です", err)
+	}
+}
@@ -120,3 +120,50 @@ func TestUserStore_Authenticate(t *testing.T) {
 		t.Errorf("存在しないユーザーを認証しようとした場合にエラーを返すことを期待しますが、実際は'%s'です", err.Error())
 	}
 }
+
+func TestUserStore_ChangePassword(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewUserStore(db)
+
+	if err := store.Create("testuser", "password123"); err != nil {
+		t.Fatalf("テストユーザーの作成に失敗しました: %v", err)
+	}
+	user, err := store.GetByUsername("testuser")
+	if err != nil {
+		t.Fatalf("ユーザー取得に失敗しました: %v", err)
+	}
+
+	// 現在のパスワードが誤っている場合は変更できない
+	err = store.ChangePassword(user.ID, "wrongpassword", "newpassword456")
+	if err == nil || err.Error() != "invalid password" {
+		t.Errorf("誤ったパスワードで'invalid password'エラーを期待しますが、実際は'%v'です", err)
+	}
+
+	if err := store.ChangePassword(user.ID, "password123", "newpassword456"); err != nil {
+		t.Fatalf("パスワードの変更に失敗しました: %v", err)
+	}
+	if _, err := store.Authenticate("testuser", "newpassword456"); err != nil {
+		t.Errorf("新しいパスワードで認証できません: %v", err)
+	}
+	if _, err := store.Authenticate("testuser", "password123"); err == nil {
+		t.Error("古いパスワードで認証できてしまいました")
+	}
+}
+
+func TestUserStore_Create_UsernameTaken(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewUserStore(db)
+
+	if err := store.Create("testuser", "password123"); err != nil {
+		t.Fatalf("テストユーザーの作成に失敗しました: %v", err)
+	}
+
+	err := store.Create("testuser", "password456")
+	if err == nil || err.Error() != "username already taken" {
+		t.Errorf("'username already taken'エラーを期待しますが、実際は'%v'です", err)
+	}
+}
@@ -0,0 +1,169 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"message-board/internal/oidc"
+	"message-board/internal/oidc/oidctest"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const redirectURL = "http://localhost:8080/login/oidc/callback"
+
+func setupProvider(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	t.Helper()
+	server, err := oidctest.NewServer("board", "secret")
+	if err != nil {
+		t.Fatalf("モックのOIDCサーバーを起動できません: %v", err)
+	}
+	t.Cleanup(server.Close)
+
+	provider := oidc.NewProvider(oidc.Config{
+		IssuerURL:    server.URL,
+		ClientID:     "board",
+		ClientSecret: "secret",
+		RedirectURL:  redirectURL,
+	}, nil)
+	return server, provider
+}
+
+// authorize は認可エンドポイントにアクセスし、リダイレクト先のクエリを返す
+func authorize(t *testing.T, provider *oidc.Provider, state, nonce, challenge string) url.Values {
+	t.Helper()
+	authURL, err := provider.AuthCodeURL(context.Background(), state, nonce, challenge)
+	if err != nil {
+		t.Fatalf("認可URLの生成に失敗しました: %v", err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("認可エンドポイントへのアクセスに失敗しました: %v", err)
+	}
+	defer resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("認可エンドポイントからリダイレクトされませんでした: %d", resp.StatusCode)
+	}
+	return location.Query()
+}
+
+func TestProvider_AuthorizationCodeFlow(t *testing.T) {
+	server, provider := setupProvider(t)
+	server.SetUser(oidctest.User{Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true, PreferredUsername: "alice"})
+
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		t.Fatalf("PKCEの生成に失敗しました: %v", err)
+	}
+
+	query := authorize(t, provider, "state-1", "nonce-1", challenge)
+	if query.Get("state") != "state-1" {
+		t.Errorf("stateが'state-1'であるべきですが、実際は'%s'です", query.Get("state"))
+	}
+
+	claims, err := provider.Exchange(context.Background(), query.Get("code"), verifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("認可コードの交換に失敗しました: %v", err)
+	}
+	if claims.Subject != "alice-sub" || claims.Email != "alice@example.com" || !claims.EmailVerified {
+		t.Errorf("クレームが正しくありません: %+v", claims)
+	}
+	if claims.Issuer != server.URL {
+		t.Errorf("発行者が'%s'であるべきですが、実際は'%s'です", server.URL, claims.Issuer)
+	}
+
+	// 認可コードは再利用できない
+	if _, err := provider.Exchange(context.Background(), query.Get("code"), verifier, "nonce-1"); err == nil {
+		t.Error("使用済みの認可コードを交換できてしまいました")
+	}
+}
+
+func TestProvider_Exchange_PKCEMismatch(t *testing.T) {
+	_, provider := setupProvider(t)
+
+	_, challenge, _ := oidc.NewPKCE()
+	query := authorize(t, provider, "state", "nonce", challenge)
+
+	if _, err := provider.Exchange(context.Background(), query.Get("code"), "wrong-verifier", "nonce"); err == nil {
+		t.Error("誤ったcode_verifierで交換できてしまいました")
+	}
+}
+
+func TestProvider_Exchange_NonceMismatch(t *testing.T) {
+	_, provider := setupProvider(t)
+
+	verifier, challenge, _ := oidc.NewPKCE()
+	query := authorize(t, provider, "state", "nonce", challenge)
+
+	if _, err := provider.Exchange(context.Background(), query.Get("code"), verifier, "other-nonce"); err == nil {
+		t.Error("nonceが一致しないIDトークンを受け入れてしまいました")
+	}
+}
+
+func TestProvider_VerifyIDToken(t *testing.T) {
+	server, provider := setupProvider(t)
+	now := time.Now()
+
+	valid := jwt.MapClaims{
+		"iss":   server.URL,
+		"sub":   "alice-sub",
+		"aud":   "board",
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Minute).Unix(),
+		"nonce": "nonce",
+	}
+	token, err := server.SignIDToken(valid)
+	if err != nil {
+		t.Fatalf("IDトークンの署名に失敗しました: %v", err)
+	}
+	if _, err := provider.VerifyIDToken(context.Background(), token, "nonce"); err != nil {
+		t.Fatalf("正しいIDトークンの検証に失敗しました: %v", err)
+	}
+
+	tests := map[string]func(jwt.MapClaims){
+		"発行者が異なる":    func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"対象者が異なる":    func(c jwt.MapClaims) { c["aud"] = "other-client" },
+		"有効期限切れ":     func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Minute).Unix() },
+		"有効期限がない":    func(c jwt.MapClaims) { delete(c, "exp") },
+		"subjectがない": func(c jwt.MapClaims) { delete(c, "sub") },
+	}
+	for name, modify := range tests {
+		claims := jwt.MapClaims{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		modify(claims)
+
+		token, err := server.SignIDToken(claims)
+		if err != nil {
+			t.Fatalf("IDトークンの署名に失敗しました: %v", err)
+		}
+		if _, err := provider.VerifyIDToken(context.Background(), token, "nonce"); err == nil {
+			t.Errorf("%s IDトークンを受け入れてしまいました", name)
+		}
+	}
+
+	// 別の鍵（HMAC）で署名されたトークンは受け入れない
+	hmacToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, valid).SignedString([]byte("secret"))
+	if _, err := provider.VerifyIDToken(context.Background(), hmacToken, "nonce"); err == nil {
+		t.Error("HS256で署名されたIDトークンを受け入れてしまいました")
+	}
+}
+
+func TestS256Challenge(t *testing.T) {
+	// RFC 7636 付録B のテストベクター
+	got := oidc.S256Challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if got != want {
+		t.Errorf("code_challengeが'%s'であるべきですが、実際は'%s'です", want, got)
+	}
+}
@@ -0,0 +1,86 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 付録B のテストベクター（SHA-1、下位6桁）
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+
+func TestCode_RFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("コードの生成に失敗しました: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("時刻%dのコードが'%s'であるべきですが、実際は'%s'です", tt.unix, tt.want, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	step, ok := Validate(rfcSecret, "050471", now)
+	if !ok || step != Step(now) {
+		t.Errorf("現在のコードが有効と判定されるべきです")
+	}
+
+	// 前後1ステップのずれは許容する
+	prev, _ := Code(rfcSecret, now.Add(-Period))
+	if _, ok := Validate(rfcSecret, prev, now); !ok {
+		t.Error("1ステップ前のコードが有効と判定されるべきです")
+	}
+	old, _ := Code(rfcSecret, now.Add(-2*Period))
+	if _, ok := Validate(rfcSecret, old, now); ok {
+		t.Error("2ステップ前のコードが有効と判定されてしまいました")
+	}
+
+	for _, code := range []string{"", "12345", "abcdef", "0504710"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("不正なコード'%s'が有効と判定されてしまいました", code)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("シークレットの生成に失敗しました: %v", err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("シークレットの長さが32であるべきですが、実際は%dです", len(secret))
+	}
+	if _, err := Code(secret, time.Now()); err != nil {
+		t.Errorf("生成したシークレットでコードを生成できません: %v", err)
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("スレッドボード", "alice", rfcSecret)
+
+	if !strings.HasPrefix(uri, "otpauth://totp/") {
+		t.Errorf("URIのスキームが正しくありません: %s", uri)
+	}
+	if !strings.Contains(uri, "secret="+rfcSecret) {
+		t.Errorf("URIにシークレットが含まれていません: %s", uri)
+	}
+	if !strings.Contains(uri, ":alice?") {
+		t.Errorf("URIにアカウント名が含まれていません: %s", uri)
+	}
+}
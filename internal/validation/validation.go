@@ -0,0 +1,140 @@
+// Package validation はユーザー入力の検証を行い、フォームの項目ごとのエラーメッセージを返す。
+package validation
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 50
+	// MaxPasswordBytes は bcrypt が扱える最大バイト数。これを超える部分は無視されてしまう。
+	MaxPasswordBytes = 72
+)
+
+// Errors はフォームの項目名とエラーメッセージの対応
+type Errors map[string]string
+
+// Add は項目にまだエラーがなければメッセージを追加する
+func (e Errors) Add(field, message string) {
+	if message == "" {
+		return
+	}
+	if _, ok := e[field]; !ok {
+		e[field] = message
+	}
+}
+
+// Any はエラーが1つ以上あるかを返す
+func (e Errors) Any() bool {
+	return len(e) > 0
+}
+
+// Username はユーザー名を検証し、問題があればメッセージを返す。
+// 使える文字は半角英数字と「_」「-」「.」で、先頭は英数字に限る。
+func Username(username string) string {
+	n := utf8.RuneCountInString(username)
+	if n == 0 {
+		return "ユーザー名を入力してください。"
+	}
+	if n < MinUsernameLength || n > MaxUsernameLength {
+		return fmt.Sprintf("ユーザー名は%d〜%d文字で入力してください。", MinUsernameLength, MaxUsernameLength)
+	}
+	for i, r := range username {
+		if !UsernameRune(r, i == 0) {
+			return "ユーザー名には半角英数字と「_」「-」「.」のみ使えます（先頭は英数字）。"
+		}
+	}
+	return ""
+}
+
+// UsernameRune は r がユーザー名に使える文字かを返す。first は先頭の文字かどうか。
+func UsernameRune(r rune, first bool) bool {
+	if ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+		return true
+	}
+	return !first && (r == '_' || r == '-' || r == '.')
+}
+
+// PasswordPolicy はパスワードの要件
+type PasswordPolicy struct {
+	MinLength int
+	// breached は漏洩が知られているパスワード（小文字に正規化）
+	breached map[string]struct{}
+}
+
+// NewPasswordPolicy は最小文字数と漏洩パスワードの一覧からポリシーを作成する
+func NewPasswordPolicy(minLength int, breached []string) *PasswordPolicy {
+	p := &PasswordPolicy{MinLength: minLength, breached: make(map[string]struct{}, len(breached))}
+	for _, password := range breached {
+		p.breached[strings.ToLower(password)] = struct{}{}
+	}
+	return p
+}
+
+// LoadBreachedPasswords は1行に1つのパスワードが書かれたファイルを読み込む。
+// 空行と「#」で始まる行は無視する。
+func LoadBreachedPasswords(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var passwords []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords = append(passwords, line)
+	}
+	return passwords, scanner.Err()
+}
+
+// Check はパスワードを検証し、問題があればメッセージを返す
+func (p *PasswordPolicy) Check(password, username string) string {
+	if password == "" {
+		return "パスワードを入力してください。"
+	}
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Sprintf("パスワードは%d文字以上で入力してください。", p.MinLength)
+	}
+	if len(password) > MaxPasswordBytes {
+		return fmt.Sprintf("パスワードが長すぎます（半角%d文字まで、全角文字は1文字あたり3文字分として数えます）。", MaxPasswordBytes)
+	}
+	if username != "" && strings.EqualFold(password, username) {
+		return "ユーザー名と同じパスワードは使えません。"
+	}
+	if _, ok := p.breached[strings.ToLower(password)]; ok {
+		return "このパスワードは漏洩したパスワードの一覧に含まれているため使えません。"
+	}
+	return ""
+}
+
+// PasswordPolicyFromEnv は環境変数の設定からパスワードポリシーを作成する。
+// PASSWORD_MIN_LENGTH（既定: 8）と BREACHED_PASSWORDS_FILE（既定: config/breached_passwords.txt）を使う。
+func PasswordPolicyFromEnv() *PasswordPolicy {
+	minLength, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH"))
+	if err != nil || minLength <= 0 {
+		minLength = 8
+	}
+
+	path := os.Getenv("BREACHED_PASSWORDS_FILE")
+	if path == "" {
+		path = "config/breached_passwords.txt"
+	}
+	breached, err := LoadBreachedPasswords(path)
+	if err != nil {
+		log.Printf("漏洩パスワードの一覧を読み込めません: %v", err)
+	}
+
+	return NewPasswordPolicy(minLength, breached)
+}
@@ -0,0 +1,80 @@
+package validation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUsername(t *testing.T) {
+	valid := []string{"abc", "alice_01", "bob.smith", "a-b", strings.Repeat("a", MaxUsernameLength)}
+	for _, username := range valid {
+		if msg := Username(username); msg != "" {
+			t.Errorf("'%s'は有効なユーザー名であるべきですが、エラーになりました: %s", username, msg)
+		}
+	}
+
+	invalid := []string{"", "ab", " alice", "alice bob", "_alice", "ありす", "alice!", strings.Repeat("a", MaxUsernameLength+1)}
+	for _, username := range invalid {
+		if Username(username) == "" {
+			t.Errorf("'%s'は無効なユーザー名であるべきですが、エラーになりません", username)
+		}
+	}
+}
+
+func TestPasswordPolicy_Check(t *testing.T) {
+	policy := NewPasswordPolicy(8, []string{"Password1"})
+
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{"", false},
+		{"short", false},
+		{"correct horse battery", true},
+		{"パスワードは八文字", true},
+		{"password1", false}, // 漏洩パスワードは大文字小文字を区別しない
+		{"testuser", false},  // ユーザー名と同じ
+		{strings.Repeat("a", MaxPasswordBytes), true},
+		{strings.Repeat("a", MaxPasswordBytes+1), false},
+		{strings.Repeat("あ", 25), false}, // 75バイト
+	}
+
+	for _, tt := range tests {
+		msg := policy.Check(tt.password, "TestUser")
+		if (msg == "") != tt.valid {
+			t.Errorf("'%s'の検証結果が正しくありません（有効: %v、メッセージ: '%s'）", tt.password, tt.valid, msg)
+		}
+	}
+}
+
+func TestLoadBreachedPasswords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	content := "# コメント\n123456\n\n  qwerty  \n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("テストファイルの作成に失敗しました: %v", err)
+	}
+
+	passwords, err := LoadBreachedPasswords(path)
+	if err != nil {
+		t.Fatalf("ファイルの読み込みに失敗しました: %v", err)
+	}
+	if len(passwords) != 2 || passwords[0] != "123456" || passwords[1] != "qwerty" {
+		t.Errorf("読み込み結果が正しくありません: %v", passwords)
+	}
+}
+
+func TestErrors_Add(t *testing.T) {
+	errs := Errors{}
+	errs.Add("username", "")
+	if errs.Any() {
+		t.Error("空のメッセージが追加されました")
+	}
+
+	errs.Add("username", "最初のエラー")
+	errs.Add("username", "次のエラー")
+	if errs["username"] != "最初のエラー" {
+		t.Errorf("最初のエラーが保持されるべきですが、実際は'%s'です", errs["username"])
+	}
+}
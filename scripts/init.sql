@@ -1,4 +1,18 @@
+-- 部分一致検索用の拡張機能
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
 -- 既存のテーブルを削除（存在する場合）
+DROP TABLE IF EXISTS message_revisions;
+DROP TABLE IF EXISTS reports;
+DROP TABLE IF EXISTS moderation_log;
+DROP TABLE IF EXISTS identities;
+DROP TABLE IF EXISTS login_attempts;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS email_verifications;
+DROP TABLE IF EXISTS password_resets;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS tokens;
+DROP TABLE IF EXISTS posts;
 DROP TABLE IF EXISTS messages;
 DROP TABLE IF EXISTS users;
 
@@ -7,9 +21,22 @@ CREATE TABLE users (
     id SERIAL PRIMARY KEY,
     username VARCHAR(50) NOT NULL UNIQUE,
     password_hash VARCHAR(255) NOT NULL,
+    email VARCHAR(254),
+    email_verified_at TIMESTAMP WITH TIME ZONE,
+    totp_secret VARCHAR(64),
+    totp_enabled_at TIMESTAMP WITH TIME ZONE,
+    totp_last_step BIGINT,
+    role VARCHAR(20) NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin')),
+    suspended_at TIMESTAMP WITH TIME ZONE,
+    suspended_until TIMESTAMP WITH TIME ZONE,
+    suspension_reason TEXT,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
 
+-- メールアドレスの一意性は確認済みのアドレスのみに適用する。
+-- 未確認のアドレスを重複不可にすると、他人のアドレスを先に登録して本人の登録を妨げられるため。
+CREATE UNIQUE INDEX users_email_idx ON users (lower(email)) WHERE email_verified_at IS NOT NULL;
+
 -- メッセージテーブルの作成
 CREATE TABLE messages (
     id SERIAL PRIMARY KEY,
@@ -17,7 +44,174 @@ CREATE TABLE messages (
     content TEXT NOT NULL,
     user_id INTEGER REFERENCES users(id),
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    hidden_at TIMESTAMP WITH TIME ZONE,
+    deleted_at TIMESTAMP WITH TIME ZONE,
+    deleted_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    search_vector tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple', coalesce(title, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce(content, '')), 'B')
+    ) STORED
+);
+
+-- 全文検索用のインデックス
+CREATE INDEX messages_search_vector_idx ON messages USING GIN (search_vector);
+
+-- 日本語の部分一致検索用のトライグラムインデックス
+CREATE INDEX messages_title_trgm_idx ON messages USING GIN (title gin_trgm_ops);
+CREATE INDEX messages_content_trgm_idx ON messages USING GIN (content gin_trgm_ops);
+
+-- ゴミ箱の一覧と完全削除用のインデックス
+CREATE INDEX messages_deleted_at_idx ON messages (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- 投稿テーブルの作成（スレッドに対する投稿）
+CREATE TABLE posts (
+    id SERIAL PRIMARY KEY,
+    message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    parent_post_id INTEGER REFERENCES posts(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    user_id INTEGER REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    -- 削除された投稿は返信を残すため、内容を空にして deleted_at を設定する
+    deleted_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX posts_message_id_idx ON posts (message_id, created_at);
+CREATE INDEX posts_parent_post_id_idx ON posts (parent_post_id);
+
+-- パーソナルアクセストークンテーブルの作成（トークンはハッシュのみ保存）
+CREATE TABLE tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    hint VARCHAR(16) NOT NULL,
+    scopes TEXT[] NOT NULL,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX tokens_user_id_idx ON tokens (user_id);
+
+-- ログインセッションテーブルの作成（リフレッシュトークンはハッシュのみ保存）
+CREATE TABLE sessions (
+    id CHAR(32) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    refresh_token_hash CHAR(64) NOT NULL,
+    previous_refresh_token_hash CHAR(64),
+    rotated_at TIMESTAMP WITH TIME ZONE,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
+
+-- パスワード再設定トークンテーブルの作成
+CREATE TABLE password_resets (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- メールアドレス確認トークンテーブルの作成
+CREATE TABLE email_verifications (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email VARCHAR(254) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 2段階認証のリカバリーコードテーブルの作成（コードはハッシュのみ保存）
+CREATE TABLE recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX recovery_codes_user_id_idx ON recovery_codes (user_id);
+
+-- ログイン試行の監査テーブルの作成
+CREATE TABLE login_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    username VARCHAR(100) NOT NULL,
+    ip_address VARCHAR(45) NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    succeeded BOOLEAN NOT NULL,
+    reason VARCHAR(32) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX login_attempts_username_idx ON login_attempts (username, created_at);
+CREATE INDEX login_attempts_ip_address_idx ON login_attempts (ip_address, created_at);
+
+-- 外部IDプロバイダー（OIDC）のアカウントとの対応テーブルの作成
+CREATE TABLE identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email VARCHAR(254) NOT NULL DEFAULT '',
+    last_login_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (issuer, subject)
+);
+
+-- モデレーションログテーブルの作成（モデレーターが他のユーザーのコンテンツに対して行った操作）
+CREATE TABLE moderation_log (
+    id SERIAL PRIMARY KEY,
+    actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    action VARCHAR(32) NOT NULL,
+    target_id INTEGER NOT NULL,
+    target_user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    details TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX moderation_log_created_at_idx ON moderation_log (created_at);
+
+-- 通報テーブルの作成（未対応の通報は同じユーザーから同じメッセージに1件まで）
+CREATE TABLE reports (
+    id SERIAL PRIMARY KEY,
+    message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    reporter_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    category VARCHAR(20) NOT NULL CHECK (category IN ('spam', 'harassment', 'inappropriate', 'other')),
+    note TEXT NOT NULL DEFAULT '',
+    resolution VARCHAR(20),
+    resolved_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    resolved_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX reports_open_idx ON reports (message_id, reporter_id) WHERE resolved_at IS NULL;
+
+-- メッセージの編集履歴テーブルの作成（編集で置き換えられる前の版を保存）
+CREATE TABLE message_revisions (
+    id SERIAL PRIMARY KEY,
+    message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    revision INTEGER NOT NULL,
+    title VARCHAR(20) NOT NULL,
+    content TEXT NOT NULL,
+    edited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    UNIQUE (message_id, revision)
 );
 
 -- テストユーザーの作成 (パスワード: 123456)
@@ -30,4 +224,9 @@ INSERT INTO messages (title, content, user_id) VALUES
     ('こんにちは世界', 'Hello World!', 1),
     ('テストメッセージ', 'これはテストメッセージです', 1),
     ('ご挨拶', '皆様、今日も良い一日を', 1),
-    ('お知らせ', 'これは重要な通知です', 1); 
\ No newline at end of file
+    ('お知らせ', 'これは重要な通知です', 1);
+
+INSERT INTO posts (message_id, parent_post_id, content, user_id) VALUES
+    (1, NULL, 'よろしくお願いします', 1),
+    (1, NULL, 'スレッドへの投稿です', 1),
+    (1, 1, 'こちらこそよろしくお願いします', 1); 
\ No newline at end of file